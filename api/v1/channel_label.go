@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ChannelLabels resolves the pod label holding the channel of the pods of
+// a namespace: the namespace annotation when set to a valid label key,
+// Default otherwise.
+type ChannelLabels struct {
+	Client client.Reader
+	// Default is the channel label of namespaces without the annotation.
+	// Defaults to KUBEORBIT_CHANNEL_LABEL.
+	Default string
+}
+
+// For returns the channel label of the pods of namespace.
+func (c *ChannelLabels) For(ctx context.Context, namespace string) (string, error) {
+	ns := &corev1.Namespace{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("Namespace %s get query error: %w", namespace, err)
+	}
+	if label := ns.Annotations[KUBEORBIT_CHANNEL_LABEL_ANNOTATION]; label != "" {
+		err := ValidateChannelLabel(label)
+		if err == nil {
+			return label, nil
+		}
+		// A bad annotation must not block the pods of the namespace.
+		podlog.Info("ignoring invalid channel label annotation", "namespace", namespace, "error", err.Error())
+	}
+	if c.Default != "" {
+		return c.Default, nil
+	}
+	return KUBEORBIT_CHANNEL_LABEL, nil
+}
+
+// ValidateChannelLabel checks that label is a qualified label key, as
+// channel labels are used in label selectors and Downward API field paths.
+func ValidateChannelLabel(label string) error {
+	if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+		return fmt.Errorf("invalid channel label %q: %s", label, strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -1,5 +1,10 @@
 package v1
 
 const (
+	// KUBEORBIT_CHANNEL_LABEL is the default pod label holding the channel.
 	KUBEORBIT_CHANNEL_LABEL = "version"
+	KUBEORBIT_CHANNEL_ENV   = "ORBIT_CHANNEL_TAG"
+	// KUBEORBIT_CHANNEL_LABEL_ANNOTATION on a namespace names the pod label
+	// holding the channel of its pods, instead of the controller default.
+	KUBEORBIT_CHANNEL_LABEL_ANNOTATION = "kubeorbit.io/channel-label"
 )
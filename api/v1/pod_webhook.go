@@ -19,33 +19,59 @@ package v1
 import (
 	"context"
 	"encoding/json"
-	corev1 "k8s.io/api/core/v1"
+	"fmt"
 	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+
+	"kubeorbit.io/api/v1alpha1"
 )
 
 var podlog = logf.Log.WithName("pod-resource")
 
-// +kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=fail,groups=core,resources=pods,verbs=create;update,versions=v1,admissionReviewVersions=v1,sideEffects=none,name=mpod.kb.io
+// +kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=fail,groups=core,resources=pods,verbs=create,versions=v1,admissionReviewVersions=v1,sideEffects=none,name=mpod.kb.io
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=network.kubeorbit.io,resources=orbits,verbs=get;list;watch
 
 type PodLabelMutate struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client        client.Client
+	ChannelLabels *ChannelLabels
+	decoder       *admission.Decoder
 }
 
-func NewPodSideCarMutate(c client.Client) admission.Handler {
-	return &PodLabelMutate{Client: c}
+// NewPodSideCarMutate creates the pod webhook. Channels are read from the
+// channelLabel pod label, unless the namespace of the pod overrides it.
+func NewPodSideCarMutate(c client.Client, channelLabel string) admission.Handler {
+	return &PodLabelMutate{Client: c, ChannelLabels: &ChannelLabels{Client: c, Default: channelLabel}}
 }
 
 const (
 	istioProxyName = "istio-proxy"
-	channelEnv     = "ORBIT_CHANNEL_TAG"
+	channelEnv     = KUBEORBIT_CHANNEL_ENV
+	// proxyConfigAnnotation overrides the mesh proxy config of a pod when
+	// the Istio sidecar is injected.
+	proxyConfigAnnotation = "proxy.istio.io/config"
+	// orbitScopeField indexes Orbits by spec.scope.
+	orbitScopeField = "spec.scope"
 )
 
-// PodLabelMutate injects a key-value pair to istio-proxy sidecar if a specific label exists.
+// PodLabelMutate passes the channel of the pod to the istio-proxy sidecar
+// as ORBIT_CHANNEL_TAG. The channel is added to the proxyMetadata of the
+// proxy config annotation, which Istio turns into sidecar environment
+// variables whenever it injects the sidecar, before or after this webhook.
+// Sidecars already injected, as containers or native sidecar init
+// containers, get the variable directly, and so do the application
+// containers selected by Orbits. Only pods being created are changed, as
+// the environment of running containers cannot be updated.
 func (v *PodLabelMutate) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
 	pod := &corev1.Pod{}
 
 	err := v.decoder.Decode(req, pod)
@@ -53,25 +79,62 @@ func (v *PodLabelMutate) Handle(ctx context.Context, req admission.Request) admi
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	label, err := v.ChannelLabels.For(ctx, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 	tag := ""
-	if val, ok := pod.Labels[KUBEORBIT_CHANNEL_LABEL]; ok {
+	// The Downward API reads the channel from where it was found.
+	fieldPath := fmt.Sprintf("metadata.labels['%s']", label)
+	if val, ok := pod.Labels[label]; ok {
+		tag = val
+	} else if val, ok := pod.Annotations[label]; ok {
 		tag = val
+		fieldPath = fmt.Sprintf("metadata.annotations['%s']", label)
+	}
+	if tag == "" {
+		return admission.Allowed("")
 	}
 
-	sidecarIndex := -1
-	for k, container := range pod.Spec.Containers {
-		if container.Name == istioProxyName {
-			sidecarIndex = k
+	config, err := withProxyMetadata(pod.Annotations[proxyConfigAnnotation], channelEnv, tag)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[proxyConfigAnnotation] = config
+
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == istioProxyName {
+			setEnv(&pod.Spec.Containers[i], channelEnv, tag)
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == istioProxyName {
+			setEnv(&pod.Spec.InitContainers[i], channelEnv, tag)
 		}
 	}
 
-	if tag != "" && sidecarIndex > 0 {
-		pod.Spec.Containers[sidecarIndex].Env = append(
-			pod.Spec.Containers[sidecarIndex].Env,
-			corev1.EnvVar{
-				Name:  channelEnv,
-				Value: tag,
-			})
+	var warnings []string
+	all, names, err := v.applicationContainers(ctx, req.Namespace, pod)
+	if err != nil {
+		// The sidecar still gets the channel; only the application
+		// containers miss it, which is not worth failing the pod for.
+		podlog.Error(err, "application containers lookup failed", "namespace", req.Namespace)
+		warnings = append(warnings, fmt.Sprintf("%s not set on application containers: %v", channelEnv, err))
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if c.Name == istioProxyName || !(all || names[c.Name]) || hasEnv(c, channelEnv) {
+			continue
+		}
+		c.Env = append(c.Env, corev1.EnvVar{
+			Name: channelEnv,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath},
+			},
+		})
 	}
 
 	marshaledPod, err := json.Marshal(pod)
@@ -79,7 +142,88 @@ func (v *PodLabelMutate) Handle(ctx context.Context, req admission.Request) admi
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod).WithWarnings(warnings...)
+}
+
+// withProxyMetadata returns the proxy config annotation value with the
+// proxyMetadata entry name set to value. The rest of the config is kept.
+func withProxyMetadata(config, name, value string) (string, error) {
+	proxyConfig := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(config), &proxyConfig); err != nil {
+		return "", fmt.Errorf("%s annotation parse error: %w", proxyConfigAnnotation, err)
+	}
+	if proxyConfig == nil {
+		proxyConfig = map[string]interface{}{}
+	}
+	metadata, ok := proxyConfig["proxyMetadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+	}
+	metadata[name] = value
+	proxyConfig["proxyMetadata"] = metadata
+
+	out, err := yaml.Marshal(proxyConfig)
+	if err != nil {
+		return "", fmt.Errorf("%s annotation marshal error: %w", proxyConfigAnnotation, err)
+	}
+	return string(out), nil
+}
+
+// IndexOrbitScope indexes Orbits by orbitScopeField, which the pod webhook
+// looks up the mesh scoped Orbits by.
+func IndexOrbitScope(ctx context.Context, indexer client.FieldIndexer) error {
+	return indexer.IndexField(ctx, &v1alpha1.Orbit{}, orbitScopeField, func(obj client.Object) []string {
+		return []string{string(obj.(*v1alpha1.Orbit).Spec.Scope)}
+	})
+}
+
+// applicationContainers returns the application containers of the pod
+// that Orbits expose the channel to: all of them, or those named. Only the
+// Orbits of the namespace and the mesh scoped ones can select the pod.
+func (v *PodLabelMutate) applicationContainers(ctx context.Context, namespace string, pod *corev1.Pod) (bool, map[string]bool, error) {
+	local := &v1alpha1.OrbitList{}
+	if err := v.Client.List(ctx, local, client.InNamespace(namespace)); err != nil {
+		return false, nil, fmt.Errorf("Orbit list query error: %w", err)
+	}
+	mesh := &v1alpha1.OrbitList{}
+	if err := v.Client.List(ctx, mesh, client.MatchingFields{orbitScopeField: string(v1alpha1.OrbitScopeMesh)}); err != nil {
+		return false, nil, fmt.Errorf("Orbit list query error: %w", err)
+	}
+	names := map[string]bool{}
+	for _, o := range append(local.Items, mesh.Items...) {
+		a := o.Spec.ApplicationEnv
+		if a == nil || !o.Selects(namespace, pod.Labels) {
+			continue
+		}
+		if len(a.Containers) == 0 {
+			return true, nil, nil
+		}
+		for _, name := range a.Containers {
+			names[name] = true
+		}
+	}
+	return false, names, nil
+}
+
+func hasEnv(container *corev1.Container, name string) bool {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setEnv sets the environment variable name of the container to value,
+// replacing any previous definition.
+func setEnv(container *corev1.Container, name, value string) {
+	for i := range container.Env {
+		if container.Env[i].Name == name {
+			container.Env[i] = corev1.EnvVar{Name: name, Value: value}
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
 }
 
 // PodLabelMutate implements admission.DecoderInjector.
@@ -0,0 +1,414 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+
+	"kubeorbit.io/api/v1alpha1"
+)
+
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+// newTestWebhook returns the pod webhook reading the objects from c.
+func newTestWebhook(t *testing.T, c client.Client) *PodLabelMutate {
+	t.Helper()
+	decoder, err := admission.NewDecoder(testScheme())
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	w := NewPodSideCarMutate(c, KUBEORBIT_CHANNEL_LABEL).(*PodLabelMutate)
+	if err := w.InjectDecoder(decoder); err != nil {
+		t.Fatalf("InjectDecoder: %v", err)
+	}
+	return w
+}
+
+// admit sends the creation of pod to the webhook and returns the response
+// along with the pod patched by it.
+func admit(t *testing.T, w *PodLabelMutate, pod *corev1.Pod) (admission.Response, *corev1.Pod) {
+	t.Helper()
+	return admitOperation(t, w, admissionv1.Create, pod)
+}
+
+func admitOperation(t *testing.T, w *PodLabelMutate, op admissionv1.Operation, pod *corev1.Pod) (admission.Response, *corev1.Pod) {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("marshal pod: %v", err)
+	}
+	resp := w.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: op,
+		Namespace: pod.Namespace,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if !resp.Allowed {
+		t.Fatalf("pod denied: %v", resp.Result)
+	}
+
+	patch, err := json.Marshal(resp.Patches)
+	if err != nil {
+		t.Fatalf("marshal patches: %v", err)
+	}
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		t.Fatalf("decode patches: %v", err)
+	}
+	patched, err := decoded.Apply(raw)
+	if err != nil {
+		t.Fatalf("apply patches: %v", err)
+	}
+	out := &corev1.Pod{}
+	if err := json.Unmarshal(patched, out); err != nil {
+		t.Fatalf("unmarshal patched pod: %v", err)
+	}
+	return resp, out
+}
+
+func testPod(containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "apps", Labels: map[string]string{"app": "reviews"}},
+	}
+	for _, name := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name, Image: name})
+	}
+	return pod
+}
+
+func container(t *testing.T, containers []corev1.Container, name string) *corev1.Container {
+	t.Helper()
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	t.Fatalf("container %s not found", name)
+	return nil
+}
+
+func envValue(c *corev1.Container, name string) (corev1.EnvVar, bool) {
+	for _, env := range c.Env {
+		if env.Name == name {
+			return env, true
+		}
+	}
+	return corev1.EnvVar{}, false
+}
+
+// proxyMetadata returns the proxyMetadata of the proxy config annotation.
+func proxyMetadata(t *testing.T, pod *corev1.Pod) map[string]interface{} {
+	t.Helper()
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(pod.Annotations[proxyConfigAnnotation]), &config); err != nil {
+		t.Fatalf("proxy config annotation: %v", err)
+	}
+	metadata, _ := config["proxyMetadata"].(map[string]interface{})
+	return metadata
+}
+
+func TestPodWebhookChannelSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        string
+	}{
+		{name: "label", labels: map[string]string{"version": "dev"}, want: "dev"},
+		{name: "annotation", annotations: map[string]string{"version": "test"}, want: "test"},
+		{
+			name:        "label wins over annotation",
+			labels:      map[string]string{"version": "dev"},
+			annotations: map[string]string{"version": "test"},
+			want:        "dev",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("app", istioProxyName)
+			for k, v := range tt.labels {
+				pod.Labels[k] = v
+			}
+			pod.Annotations = tt.annotations
+
+			_, out := admit(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).Build()), pod)
+			if got := proxyMetadata(t, out)[channelEnv]; got != tt.want {
+				t.Errorf("proxyMetadata %s = %v, want %s", channelEnv, got, tt.want)
+			}
+			env, ok := envValue(container(t, out.Spec.Containers, istioProxyName), channelEnv)
+			if !ok || env.Value != tt.want {
+				t.Errorf("sidecar %s = %+v, want %s", channelEnv, env, tt.want)
+			}
+			if _, ok := envValue(container(t, out.Spec.Containers, "app"), channelEnv); ok {
+				t.Errorf("application container got %s without an Orbit asking for it", channelEnv)
+			}
+		})
+	}
+}
+
+func TestPodWebhookNamespaceChannelLabel(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "apps",
+		Annotations: map[string]string{KUBEORBIT_CHANNEL_LABEL_ANNOTATION: "kubeorbit.io/channel"},
+	}}
+	pod := testPod("app")
+	pod.Labels["version"] = "v1"
+	pod.Labels["kubeorbit.io/channel"] = "dev"
+
+	_, out := admit(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(ns).Build()), pod)
+	if got := proxyMetadata(t, out)[channelEnv]; got != "dev" {
+		t.Errorf("proxyMetadata %s = %v, want dev", channelEnv, got)
+	}
+}
+
+func TestPodWebhookExistingProxyConfig(t *testing.T) {
+	pod := testPod("app")
+	pod.Labels["version"] = "dev"
+	pod.Annotations = map[string]string{
+		proxyConfigAnnotation: "holdApplicationUntilProxyStarts: true\nproxyMetadata:\n  TENANT_ID: acme\n  ORBIT_CHANNEL_TAG: stale\n",
+	}
+
+	_, out := admit(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).Build()), pod)
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(out.Annotations[proxyConfigAnnotation]), &config); err != nil {
+		t.Fatalf("proxy config annotation: %v", err)
+	}
+	if config["holdApplicationUntilProxyStarts"] != true {
+		t.Errorf("proxy config lost holdApplicationUntilProxyStarts: %v", config)
+	}
+	metadata := proxyMetadata(t, out)
+	if metadata["TENANT_ID"] != "acme" || metadata[channelEnv] != "dev" {
+		t.Errorf("proxyMetadata = %v, want TENANT_ID kept and %s set to dev", metadata, channelEnv)
+	}
+}
+
+func TestPodWebhookInvalidProxyConfig(t *testing.T) {
+	pod := testPod("app")
+	pod.Labels["version"] = "dev"
+	pod.Annotations = map[string]string{proxyConfigAnnotation: "proxyMetadata: ["}
+	raw, _ := json.Marshal(pod)
+
+	w := newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).Build())
+	resp := w.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: pod.Namespace,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if resp.Allowed {
+		t.Errorf("pod with an invalid %s annotation allowed", proxyConfigAnnotation)
+	}
+}
+
+func TestPodWebhookNativeSidecar(t *testing.T) {
+	pod := testPod("app")
+	pod.Labels["version"] = "dev"
+	pod.Spec.InitContainers = []corev1.Container{
+		{Name: "istio-init", Image: "proxyv2"},
+		// Native sidecars are init containers with restartPolicy Always,
+		// which the API of this module predates.
+		{Name: istioProxyName, Image: "proxyv2", Env: []corev1.EnvVar{{Name: channelEnv, Value: "stale"}}},
+	}
+
+	_, out := admit(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).Build()), pod)
+	sidecar := container(t, out.Spec.InitContainers, istioProxyName)
+	if len(sidecar.Env) != 1 || sidecar.Env[0].Value != "dev" {
+		t.Errorf("native sidecar env = %+v, want %s=dev once", sidecar.Env, channelEnv)
+	}
+	if _, ok := envValue(container(t, out.Spec.InitContainers, "istio-init"), channelEnv); ok {
+		t.Errorf("init container istio-init got %s", channelEnv)
+	}
+}
+
+func TestPodWebhookWithoutChannel(t *testing.T) {
+	resp, _ := admit(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).Build()), testPod("app", istioProxyName))
+	if len(resp.Patches) != 0 {
+		t.Errorf("pod without channel patched: %v", resp.Patches)
+	}
+}
+
+func TestPodWebhookApplicationEnv(t *testing.T) {
+	orbit := func(namespace, name string, scope v1alpha1.OrbitScope, selector map[string]string, containers ...string) *v1alpha1.Orbit {
+		o := &v1alpha1.Orbit{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: v1alpha1.OrbitSpec{
+				MeshProvider:   "istio",
+				Scope:          scope,
+				ApplicationEnv: &v1alpha1.ApplicationEnvSpec{Containers: containers},
+			},
+		}
+		if selector != nil {
+			o.Spec.WorkloadSelector = &v1alpha1.WorkloadSelector{Labels: selector}
+		}
+		return o
+	}
+	tests := []struct {
+		name     string
+		orbits   []client.Object
+		fromAnno bool
+		// want lists the containers expected to get the variable.
+		want []string
+	}{
+		{name: "no Orbit"},
+		{
+			name:   "named container",
+			orbits: []client.Object{orbit("apps", "named", "", nil, "app")},
+			want:   []string{"app"},
+		},
+		{
+			name:   "every container",
+			orbits: []client.Object{orbit("apps", "all", "", map[string]string{"app": "reviews"})},
+			want:   []string{"app", "worker"},
+		},
+		{
+			name:   "workload not selected",
+			orbits: []client.Object{orbit("apps", "ratings", "", map[string]string{"app": "ratings"})},
+		},
+		{
+			name:   "namespace Orbit of another namespace",
+			orbits: []client.Object{orbit("other", "all", v1alpha1.OrbitScopeNamespace, nil)},
+		},
+		{
+			name:   "mesh Orbit of another namespace",
+			orbits: []client.Object{orbit("istio-system", "all", v1alpha1.OrbitScopeMesh, nil, "worker")},
+			want:   []string{"worker"},
+		},
+		{
+			name:     "channel from an annotation",
+			orbits:   []client.Object{orbit("apps", "named", "", nil, "app")},
+			fromAnno: true,
+			want:     []string{"app"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("app", "worker", istioProxyName)
+			fieldPath := "metadata.labels['version']"
+			if tt.fromAnno {
+				pod.Annotations = map[string]string{"version": "dev"}
+				fieldPath = "metadata.annotations['version']"
+			} else {
+				pod.Labels["version"] = "dev"
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(tt.orbits...).Build()
+
+			resp, out := admit(t, newTestWebhook(t, c), pod)
+			if len(resp.Warnings) > 0 {
+				t.Errorf("unexpected warnings %v", resp.Warnings)
+			}
+			want := map[string]bool{}
+			for _, name := range tt.want {
+				want[name] = true
+			}
+			for _, name := range []string{"app", "worker"} {
+				env, ok := envValue(container(t, out.Spec.Containers, name), channelEnv)
+				switch {
+				case ok != want[name]:
+					t.Errorf("container %s has %s: %v, want %v", name, channelEnv, ok, want[name])
+				case ok && (env.ValueFrom == nil || env.ValueFrom.FieldRef == nil || env.ValueFrom.FieldRef.FieldPath != fieldPath):
+					t.Errorf("container %s %s = %+v, want a %s field reference", name, channelEnv, env, fieldPath)
+				}
+			}
+			// The sidecar gets the value itself, whatever the Orbits.
+			if env, _ := envValue(container(t, out.Spec.Containers, istioProxyName), channelEnv); env.Value != "dev" {
+				t.Errorf("sidecar %s = %+v, want dev", channelEnv, env)
+			}
+		})
+	}
+}
+
+func TestPodWebhookApplicationEnvKeepsDefinedVariable(t *testing.T) {
+	pod := testPod("app")
+	pod.Labels["version"] = "dev"
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: channelEnv, Value: "pinned"}}
+	o := &v1alpha1.Orbit{
+		ObjectMeta: metav1.ObjectMeta{Name: "all", Namespace: "apps"},
+		Spec:       v1alpha1.OrbitSpec{MeshProvider: "istio", ApplicationEnv: &v1alpha1.ApplicationEnvSpec{}},
+	}
+
+	_, out := admit(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(o).Build()), pod)
+	if env := out.Spec.Containers[0].Env; len(env) != 1 || env[0].Value != "pinned" {
+		t.Errorf("app env = %+v, want the pinned value alone", env)
+	}
+}
+
+// failingList fails every List call.
+type failingList struct {
+	client.Client
+}
+
+func (failingList) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return errors.New("cache not synced")
+}
+
+func TestPodWebhookOrbitLookupFailure(t *testing.T) {
+	pod := testPod("app", istioProxyName)
+	pod.Labels["version"] = "dev"
+	c := failingList{fake.NewClientBuilder().WithScheme(testScheme()).Build()}
+
+	resp, out := admit(t, newTestWebhook(t, c), pod)
+	if len(resp.Warnings) != 1 {
+		t.Errorf("warnings = %v, want one", resp.Warnings)
+	}
+	if got := proxyMetadata(t, out)[channelEnv]; got != "dev" {
+		t.Errorf("proxyMetadata %s = %v, want dev", channelEnv, got)
+	}
+	if _, ok := envValue(container(t, out.Spec.Containers, "app"), channelEnv); ok {
+		t.Errorf("application container got %s without Orbits", channelEnv)
+	}
+}
+
+func TestPodWebhookIgnoresUpdates(t *testing.T) {
+	pod := testPod("app", istioProxyName)
+	pod.Labels["version"] = "dev"
+
+	resp, _ := admitOperation(t, newTestWebhook(t, fake.NewClientBuilder().WithScheme(testScheme()).Build()), admissionv1.Update, pod)
+	if len(resp.Patches) != 0 {
+		t.Errorf("pod update patched: %v", resp.Patches)
+	}
+}
+
+func TestChannelLabelsInvalidAnnotation(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "apps",
+		Annotations: map[string]string{KUBEORBIT_CHANNEL_LABEL_ANNOTATION: "channel']"},
+	}}
+	labels := &ChannelLabels{Client: fake.NewClientBuilder().WithScheme(testScheme()).WithObjects(ns).Build(), Default: "track"}
+	label, err := labels.For(context.Background(), "apps")
+	if err != nil || label != "track" {
+		t.Errorf("For() = %q, %v, want the default label", label, err)
+	}
+}
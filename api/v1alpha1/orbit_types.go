@@ -17,31 +17,165 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type TrafficRulesSpec struct {
+	// Headers lists the request headers to propagate, keyed by header name.
+	// Each header takes its value from ORBIT_CHANNEL_TAG; the map values are
+	// ignored. Use Propagate to pick another value source.
 	Headers map[string]string `json:"headers,omitempty"`
+
+	// Propagate lists the request headers to propagate along with the
+	// source of their values.
+	Propagate []HeaderPropagation `json:"propagate,omitempty"`
+}
+
+// PropagatedHeaders merges the legacy header map with the explicit
+// propagation rules. Explicit rules win over map entries of the same name.
+func (t *TrafficRulesSpec) PropagatedHeaders() []HeaderPropagation {
+	explicit := make(map[string]bool, len(t.Propagate))
+	for _, h := range t.Propagate {
+		explicit[strings.ToLower(h.Name)] = true
+	}
+
+	legacy := make([]string, 0, len(t.Headers))
+	for k := range t.Headers {
+		if !explicit[strings.ToLower(k)] {
+			legacy = append(legacy, k)
+		}
+	}
+	sort.Strings(legacy)
+
+	headers := make([]HeaderPropagation, 0, len(legacy)+len(t.Propagate))
+	for _, k := range legacy {
+		headers = append(headers, HeaderPropagation{Name: k})
+	}
+	return append(headers, t.Propagate...)
+}
+
+// HeaderPropagation describes a request header set on outbound requests
+// when the caller has not set it already.
+type HeaderPropagation struct {
+	// Name of the request header.
+	Name string `json:"name"`
+
+	// Value is a literal value for the header.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Env names the sidecar environment variable holding the header value.
+	// The filter runs in the sidecar and cannot read the environment of the
+	// application containers: the variable must be set on the sidecar, e.g.
+	// through the proxyMetadata of the proxy.istio.io/config pod annotation
+	// or of the mesh config. Defaults to ORBIT_CHANNEL_TAG, which the pod
+	// webhook sets. Ignored when Value is set.
+	// +optional
+	Env string `json:"env,omitempty"`
 }
 
+// WorkloadSelector selects the workloads an Orbit applies to by pod labels.
+type WorkloadSelector struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// OrbitScope tells where the generated configuration is placed.
+// +kubebuilder:validation:Enum=Namespace;Mesh
+type OrbitScope string
+
+const (
+	// OrbitScopeNamespace applies the Orbit to workloads in its own namespace.
+	OrbitScopeNamespace OrbitScope = "Namespace"
+	// OrbitScopeMesh places the generated configuration in the mesh root
+	// namespace so that it applies to workloads in every namespace.
+	OrbitScopeMesh OrbitScope = "Mesh"
+)
+
 // OrbitSpec defines the desired state of Orbit
 type OrbitSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	MeshProvider string           `json:"provider"`
+	// MeshProvider selects the data plane the headers are propagated by.
+	// Defaults to the controller's default provider.
+	// +optional
+	MeshProvider string `json:"provider,omitempty"`
+
 	TrafficRules TrafficRulesSpec `json:"trafficRules"`
+
+	// WorkloadSelector limits header propagation to the selected workloads.
+	// Every workload in scope is selected when unset.
+	// +optional
+	WorkloadSelector *WorkloadSelector `json:"workloadSelector,omitempty"`
+
+	// Scope is either Namespace or Mesh. Defaults to Namespace.
+	// +optional
+	// +kubebuilder:default=Namespace
+	Scope OrbitScope `json:"scope,omitempty"`
+
+	// ApplicationEnv exposes the channel of the selected workloads to their
+	// application containers, not only to the sidecar.
+	// +optional
+	ApplicationEnv *ApplicationEnvSpec `json:"applicationEnv,omitempty"`
+}
+
+// ApplicationEnvSpec selects the application containers the pod webhook
+// sets ORBIT_CHANNEL_TAG on. The variable reads the channel label of the
+// pod through the Downward API. Containers defining it already are left
+// alone.
+type ApplicationEnvSpec struct {
+	// Containers lists the names of the containers to set the variable on.
+	// Every container but the sidecar when empty.
+	// +optional
+	Containers []string `json:"containers,omitempty"`
 }
 
+// Condition types reported on an Orbit.
+const (
+	// OrbitConditionReady is true once every other condition is true.
+	OrbitConditionReady = "Ready"
+	// OrbitConditionEnvoyFilterSynced tells whether the EnvoyFilter generated
+	// by the istio provider matches the Orbit spec.
+	OrbitConditionEnvoyFilterSynced = "EnvoyFilterSynced"
+	// OrbitConditionPropagationSynced tells whether the data plane
+	// propagates the headers of the Orbit spec. The linkerd and gateway-api
+	// providers only publish the rules for the applications to apply, and
+	// report it false with the PropagationUnsupported reason.
+	OrbitConditionPropagationSynced = "PropagationSynced"
+	// OrbitConditionProviderSupported tells whether spec.provider names a
+	// mesh provider enabled in the controller.
+	OrbitConditionProviderSupported = "ProviderSupported"
+)
+
 // OrbitStatus defines the observed state of Orbit
 type OrbitStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-	Status string `json:"Status"`
+	// ObservedGeneration is the generation of the spec last processed by
+	// the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the current state of the Orbit.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// EnvoyFilterRef references the EnvoyFilter generated for this Orbit.
+	// +optional
+	EnvoyFilterRef *corev1.ObjectReference `json:"envoyFilterRef,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.provider`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Orbit is the Schema for the orbits API
 type Orbit struct {
@@ -60,6 +194,18 @@ type OrbitList struct {
 	Items           []Orbit `json:"items"`
 }
 
+// Selects tells whether the Orbit applies to the pods of namespace carrying
+// podLabels.
+func (o *Orbit) Selects(namespace string, podLabels map[string]string) bool {
+	if o.Spec.Scope != OrbitScopeMesh && o.Namespace != namespace {
+		return false
+	}
+	if o.Spec.WorkloadSelector == nil {
+		return true
+	}
+	return labels.SelectorFromSet(o.Spec.WorkloadSelector.Labels).Matches(labels.Set(podLabels))
+}
+
 func init() {
 	SchemeBuilder.Register(&Orbit{}, &OrbitList{})
 }
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var orbitlog = logf.Log.WithName("orbit-resource")
+
+// OrbitWebhook defaults and validates Orbits.
+// +kubebuilder:object:generate=false
+type OrbitWebhook struct {
+	// Providers lists the mesh providers known to the controller, which
+	// spec.provider must name.
+	Providers []string
+	// DefaultProvider is set on Orbits that select no provider.
+	DefaultProvider string
+}
+
+func (w *OrbitWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&Orbit{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-network-kubeorbit-io-v1alpha1-orbit,mutating=true,failurePolicy=fail,sideEffects=None,groups=network.kubeorbit.io,resources=orbits,verbs=create;update,versions=v1alpha1,name=morbit.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomDefaulter = &OrbitWebhook{}
+
+// Default implements admission.CustomDefaulter. It selects the default
+// provider, like for ServiceRoutes.
+func (w *OrbitWebhook) Default(_ context.Context, obj runtime.Object) error {
+	r, ok := obj.(*Orbit)
+	if !ok {
+		return fmt.Errorf("expected an Orbit but got a %T", obj)
+	}
+	if r.Spec.MeshProvider == "" {
+		r.Spec.MeshProvider = w.DefaultProvider
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-network-kubeorbit-io-v1alpha1-orbit,mutating=false,failurePolicy=fail,sideEffects=None,groups=network.kubeorbit.io,resources=orbits,verbs=create;update,versions=v1alpha1,name=vorbit.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomValidator = &OrbitWebhook{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (w *OrbitWebhook) ValidateCreate(_ context.Context, obj runtime.Object) error {
+	r, ok := obj.(*Orbit)
+	if !ok {
+		return fmt.Errorf("expected an Orbit but got a %T", obj)
+	}
+	orbitlog.Info("validate create", "name", r.Name)
+	return r.validate(w.Providers)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (w *OrbitWebhook) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) error {
+	r, ok := newObj.(*Orbit)
+	if !ok {
+		return fmt.Errorf("expected an Orbit but got a %T", newObj)
+	}
+	orbitlog.Info("validate update", "name", r.Name)
+	// Objects admitted before a rule was added must still let the
+	// controller update their finalizers.
+	if o, ok := oldObj.(*Orbit); ok && equality.Semantic.DeepEqual(o.Spec, r.Spec) {
+		return nil
+	}
+	return r.validate(w.Providers)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (w *OrbitWebhook) ValidateDelete(_ context.Context, _ runtime.Object) error {
+	return nil
+}
+
+// validate checks what ends up in generated proxy configuration, such as
+// the Lua source of the istio provider: header names and values, and the
+// environment variables values are read from.
+func (r *Orbit) validate(providers []string) error {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	errs = append(errs, validateProvider(spec.Child("provider"), r.Spec.MeshProvider, providers)...)
+
+	// Propagate rules override the headers map entries of the same name, so
+	// names only need to be unique within each of them.
+	rules := spec.Child("trafficRules")
+	seen := map[string]bool{}
+	names := make([]string, 0, len(r.Spec.TrafficRules.Headers))
+	for name := range r.Spec.TrafficRules.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := rules.Child("headers").Key(name)
+		errs = append(errs, validateHeaderName(path, name)...)
+		if seen[strings.ToLower(name)] {
+			errs = append(errs, field.Duplicate(path, name))
+		}
+		seen[strings.ToLower(name)] = true
+	}
+	seen = map[string]bool{}
+	for i, h := range r.Spec.TrafficRules.Propagate {
+		path := rules.Child("propagate").Index(i)
+		errs = append(errs, validateHeaderName(path.Child("name"), h.Name)...)
+		if seen[strings.ToLower(h.Name)] {
+			errs = append(errs, field.Duplicate(path.Child("name"), h.Name))
+		}
+		seen[strings.ToLower(h.Name)] = true
+		errs = append(errs, validateHeaderValue(path.Child("value"), h.Value)...)
+		if h.Env != "" {
+			for _, msg := range validation.IsEnvVarName(h.Env) {
+				errs = append(errs, field.Invalid(path.Child("env"), h.Env, msg))
+			}
+		}
+	}
+
+	if s := r.Spec.WorkloadSelector; s != nil {
+		errs = append(errs, metav1validation.ValidateLabels(s.Labels, spec.Child("workloadSelector", "labels"))...)
+	}
+	if a := r.Spec.ApplicationEnv; a != nil {
+		for i, name := range a.Containers {
+			for _, msg := range validation.IsDNS1123Label(name) {
+				errs = append(errs, field.Invalid(spec.Child("applicationEnv", "containers").Index(i), name, msg))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Orbit").GroupKind(), r.Name, errs)
+}
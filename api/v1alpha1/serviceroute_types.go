@@ -17,16 +17,313 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"regexp"
+	"sort"
+	"strings"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type TrafficRouteSpec struct {
-	TrafficSubset []*Subset         `json:"routes"`
-	Default       map[string]string `json:"default"`
+	// Routes send the requests meeting their conditions to a subset. They
+	// may be left out when Discovery generates them.
+	// +optional
+	TrafficSubset []*Subset `json:"routes,omitempty"`
+
+	// Baseline takes the traffic matching no route.
+	// +optional
+	Baseline *BaselineRoute `json:"baseline,omitempty"`
+
+	// Default holds the labels of the subset taking the traffic matching no
+	// route, named after the first label value by key.
+	//
+	// Deprecated: use Baseline. The ServiceRoute webhook moves Default
+	// there.
+	// +optional
+	Default map[string]string `json:"default,omitempty"`
+
+	// Discovery generates a subset and a header route for every channel
+	// found on the pods of the routed Service, next to the routes above.
+	// +optional
+	Discovery *DiscoverySpec `json:"discovery,omitempty"`
+
+	// Mirror shadows a share of the traffic matching no route to a subset.
+	// +optional
+	Mirror *MirrorRoute `json:"mirror,omitempty"`
+}
+
+// MirrorRoute sends copies of requests to a subset. The responses of the
+// subset are discarded, so that it can be tested against real traffic
+// without affecting callers.
+type MirrorRoute struct {
+	// Subset is the name of a subset of the ServiceRoute.
+	Subset string `json:"subset"`
+
+	// Percentage of the requests that are mirrored. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// MirrorPercentage returns the percentage of the requests that are
+// mirrored.
+func (m *MirrorRoute) MirrorPercentage() int32 {
+	if m.Percentage == nil {
+		return 100
+	}
+	return *m.Percentage
+}
+
+// DiscoverySpec tells how channels are found on pods and selected by
+// requests.
+type DiscoverySpec struct {
+	// Label is the pod label holding the channel. Defaults to the channel
+	// label of the namespace of the routed Service, the one read by the pod
+	// webhook.
+	// +optional
+	Label string `json:"label,omitempty"`
+
+	// Header is the request header selecting the channel. Defaults to the
+	// name of the label, without its prefix.
+	// +optional
+	Header string `json:"header,omitempty"`
+
+	// Fallback is set on the generated subsets.
+	// +optional
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// ChannelLabel returns the pod label holding the channel, defaultLabel
+// when Label is unset.
+func (d *DiscoverySpec) ChannelLabel(defaultLabel string) string {
+	if d.Label != "" {
+		return d.Label
+	}
+	return defaultLabel
 }
 
+// ChannelHeader returns the request header selecting the channel, given
+// the pod label holding it. Label prefixes are dropped, as header names
+// cannot hold a slash.
+func (d *DiscoverySpec) ChannelHeader(label string) string {
+	if d.Header != "" {
+		return d.Header
+	}
+	return label[strings.LastIndex(label, "/")+1:]
+}
+
+// BaselineRoute is the terminal route of a ServiceRoute.
+type BaselineRoute struct {
+	// Name of the baseline subset. Without labels, it names one of the
+	// subsets of the routes.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Labels select the pods of the baseline subset.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Weights splits the traffic between subsets, instead of sending it
+	// all to the baseline subset.
+	// +optional
+	Weights []WeightedDestination `json:"weights,omitempty"`
+
+	RoutePolicy `json:",inline"`
+}
+
+// RoutePolicy tunes how the requests taken by a route are handled. It is
+// rendered by the istio provider; the other providers reject routes
+// setting it.
+type RoutePolicy struct {
+	// Timeout of the requests, retries included. Defaults to the timeout
+	// of the mesh.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Retries of the failed requests. Defaults to the retries of the mesh.
+	// +optional
+	Retries *HTTPRetry `json:"retries,omitempty"`
+
+	// Fault injects delays and aborts into the requests.
+	// +optional
+	Fault *HTTPFaultInjection `json:"fault,omitempty"`
+}
+
+// IsSet tells whether any field of the policy is set.
+func (p *RoutePolicy) IsSet() bool {
+	return p.Timeout != nil || p.Retries != nil || p.Fault != nil
+}
+
+// HTTPRetry describes the retries of a route.
+type HTTPRetry struct {
+	// Attempts is the number of retries. 0 disables retries.
+	// +kubebuilder:validation:Minimum=0
+	Attempts int32 `json:"attempts"`
+
+	// PerTryTimeout is the timeout of each attempt.
+	// +optional
+	PerTryTimeout *metav1.Duration `json:"perTryTimeout,omitempty"`
+
+	// RetryOn lists the conditions to retry on, separated by commas, such
+	// as 5xx,connect-failure.
+	// +optional
+	RetryOn string `json:"retryOn,omitempty"`
+}
+
+// HTTPFaultInjection describes the faults injected into the requests of a
+// route.
+type HTTPFaultInjection struct {
+	// Delay holds the requests before forwarding them.
+	// +optional
+	Delay *FaultDelay `json:"delay,omitempty"`
+
+	// Abort answers the requests with an error status instead of
+	// forwarding them.
+	// +optional
+	Abort *FaultAbort `json:"abort,omitempty"`
+}
+
+// FaultDelay delays a share of the requests.
+type FaultDelay struct {
+	// FixedDelay is how long the requests are held.
+	FixedDelay metav1.Duration `json:"fixedDelay"`
+
+	// Percentage of the requests that are delayed. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// DelayPercentage returns the percentage of the requests that are delayed.
+func (d *FaultDelay) DelayPercentage() int32 {
+	if d.Percentage == nil {
+		return 100
+	}
+	return *d.Percentage
+}
+
+// FaultAbort aborts a share of the requests.
+type FaultAbort struct {
+	// HTTPStatus is the status the aborted requests are answered with.
+	// +kubebuilder:validation:Minimum=200
+	// +kubebuilder:validation:Maximum=599
+	HTTPStatus int32 `json:"httpStatus"`
+
+	// Percentage of the requests that are aborted. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// Subset returns the subset defined by the baseline, nil when it only
+// refers to the subsets of the routes.
+func (b *BaselineRoute) Subset() *Subset {
+	if b == nil || b.Name == "" || b.Labels == nil {
+		return nil
+	}
+	return &Subset{Name: b.Name, Labels: b.Labels}
+}
+
+// BaselineRoute returns the baseline of the ServiceRoute, nil when it has
+// none. Objects still using the deprecated default map get the baseline
+// the webhook would migrate it to.
+func (t *TrafficRouteSpec) BaselineRoute() *BaselineRoute {
+	if t.Baseline != nil {
+		return t.Baseline
+	}
+	return baselineFromDefault(t.Default)
+}
+
+// baselineFromDefault converts the deprecated default map. The subset is
+// named after the first label value by key, the one the map used to route
+// to.
+func baselineFromDefault(defaultRoute map[string]string) *BaselineRoute {
+	keys := make([]string, 0, len(defaultRoute))
+	for k := range defaultRoute {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if c := defaultRoute[k]; c != "" {
+			labels := make(map[string]string, len(defaultRoute))
+			for k, v := range defaultRoute {
+				labels[k] = v
+			}
+			return &BaselineRoute{Name: c, Labels: labels}
+		}
+	}
+	return nil
+}
+
+// DefaultDestinations returns where the traffic matching no route goes,
+// nothing when the ServiceRoute has no baseline.
+func (t *TrafficRouteSpec) DefaultDestinations() []WeightedDestination {
+	b := t.BaselineRoute()
+	switch {
+	case b == nil:
+		return nil
+	case len(b.Weights) > 0:
+		return b.Weights
+	case b.Name != "":
+		return []WeightedDestination{{Subset: b.Name, Weight: 100}}
+	default:
+		return nil
+	}
+}
+
+// AbortPercentage returns the percentage of the requests that are aborted.
+func (a *FaultAbort) AbortPercentage() int32 {
+	if a.Percentage == nil {
+		return 100
+	}
+	return *a.Percentage
+}
+
+// WeightedDestination sends a share of the traffic of a route to a subset.
+type WeightedDestination struct {
+	// Subset is the name of a subset of the ServiceRoute.
+	Subset string `json:"subset"`
+
+	// Weight is the percentage of the traffic sent to the subset. The
+	// weights of a route sum to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+}
+
+// HTTPMatchRequest holds the conditions a request must all meet to be
+// routed to a subset.
 type HTTPMatchRequest struct {
+	// URI matches the request path.
+	// +optional
+	URI *StringMatch `json:"uri,omitempty"`
+
+	// Method matches the HTTP method.
+	// +optional
+	Method *StringMatch `json:"method,omitempty"`
+
+	// Authority matches the HTTP authority, the Host header.
+	// +optional
+	Authority *StringMatch `json:"authority,omitempty"`
+
+	// Headers match request headers by name. Header names are case
+	// insensitive.
+	// +optional
 	Headers map[string]*StringMatch `json:"headers,omitempty"`
+
+	// QueryParams match query parameters by name.
+	// +optional
+	QueryParams map[string]*StringMatch `json:"queryParams,omitempty"`
+
+	// WithoutHeaders matches requests whose headers do not match, or which
+	// lack the header.
+	// +optional
+	WithoutHeaders map[string]*StringMatch `json:"withoutHeaders,omitempty"`
 }
 
 type Subset struct {
@@ -37,6 +334,78 @@ type Subset struct {
 	// service registry. See route rules for examples of usage.
 	Labels  map[string]string       `json:"labels,omitempty"`
 	Headers map[string]*StringMatch `json:"headers,omitempty"`
+
+	// Match narrows the requests routed to the subset. Its headers add to
+	// the ones above and must not repeat them; a request must meet every
+	// condition.
+	// +optional
+	Match *HTTPMatchRequest `json:"match,omitempty"`
+
+	// Weights splits the traffic matching the subset between subsets,
+	// instead of sending it all to this one.
+	// +optional
+	Weights []WeightedDestination `json:"weights,omitempty"`
+
+	// Fallback leaves the route out while none of its destinations has a
+	// ready endpoint, so that the matching requests fall through to the
+	// baseline instead of failing. The route comes back with the endpoints.
+	// +optional
+	Fallback bool `json:"fallback,omitempty"`
+
+	RoutePolicy `json:",inline"`
+}
+
+// Active tells whether the route of the subset is rendered given the
+// endpoints reported in status. Only fallback routes are ever inactive;
+// subsets missing from status count as available.
+func (s *Subset) Active(status *ServiceRouteStatus) bool {
+	if !s.Fallback || status == nil {
+		return true
+	}
+	for _, d := range s.Destinations() {
+		if d.Weight > 0 && status.available(d.Subset) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routed tells whether the subset has a route of its own: conditions, and
+// labels or weights to send the traffic to. Subsets without conditions only
+// serve as destinations of weighted routes.
+func (s *Subset) Routed() bool {
+	if s.Labels == nil && len(s.Weights) == 0 {
+		return false
+	}
+	m := s.HTTPMatch()
+	return len(m.Headers) > 0 || m.URI != nil || m.Method != nil || m.Authority != nil ||
+		len(m.QueryParams) > 0 || len(m.WithoutHeaders) > 0
+}
+
+// Destinations returns where the traffic matching the subset goes.
+func (s *Subset) Destinations() []WeightedDestination {
+	if len(s.Weights) > 0 {
+		return s.Weights
+	}
+	return []WeightedDestination{{Subset: s.Name, Weight: 100}}
+}
+
+// HTTPMatch returns every condition of the subset in one match, merging
+// Headers into the match headers.
+func (s *Subset) HTTPMatch() *HTTPMatchRequest {
+	match := &HTTPMatchRequest{}
+	if s.Match != nil {
+		match = s.Match.DeepCopy()
+	}
+	if len(s.Headers) > 0 && match.Headers == nil {
+		match.Headers = make(map[string]*StringMatch, len(s.Headers))
+	}
+	for k, v := range s.Headers {
+		if _, ok := match.Headers[k]; !ok {
+			match.Headers[k] = v
+		}
+	}
+	return match
 }
 
 // ServiceRouteSpec defines the desired state of ServiceRoute
@@ -44,17 +413,133 @@ type ServiceRouteSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	TrafficRoutes TrafficRouteSpec `json:"trafficRoutes"`
-	Name          string           `json:"name"`
+
+	// Name of the routed Service, in the namespace of the ServiceRoute.
+	// One of name or host must be set.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Host is the routed host: the FQDN of a Service of any namespace, such
+	// as reviews.bookinfo.svc.cluster.local, or a ServiceEntry host. Other
+	// namespaces and ServiceEntry hosts are only routed by the istio
+	// provider.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// ExportTo lists the namespaces the generated routes are visible to:
+	// "." for the namespace of the ServiceRoute, "*" for every namespace.
+	// Defaults to the mesh default. Only rendered by the istio provider.
+	// +optional
+	ExportTo []string `json:"exportTo,omitempty"`
+
+	// Merge injects the routes into an existing VirtualService instead of
+	// generating one. Only rendered by the istio provider.
+	// +optional
+	Merge *MergeSpec `json:"merge,omitempty"`
+
+	// MeshProvider selects the data plane the routes are rendered for.
+	// Defaults to the controller's default provider.
+	// +optional
+	MeshProvider string `json:"provider,omitempty"`
+}
+
+// MergeSpec names the VirtualService the routes of a ServiceRoute are
+// merged into. The subset routes are put ahead of the routes of the
+// VirtualService, which take the rest of the traffic: the baseline route is
+// left out, and its timeout, retries, fault and the mirror may not be set.
+// The injected routes are removed with the ServiceRoute.
+type MergeSpec struct {
+	// VirtualService is the name of a VirtualService of the namespace of
+	// the ServiceRoute that routes its host.
+	VirtualService string `json:"virtualService"`
+}
+
+// Condition types reported on a ServiceRoute.
+const (
+	// ServiceRouteConditionReady is true once every other condition is true.
+	ServiceRouteConditionReady = "Ready"
+	// ServiceRouteConditionProviderSupported tells whether spec.provider
+	// names a mesh provider enabled in the controller.
+	ServiceRouteConditionProviderSupported = "ProviderSupported"
+	// ServiceRouteConditionRouterAvailable tells whether the orbit-router
+	// deployed by the none provider is available. The routed Service is
+	// only pointed at the router once it is.
+	ServiceRouteConditionRouterAvailable = "RouterAvailable"
+	// ServiceRouteConditionDestinationRuleSynced tells whether the
+	// DestinationRule generated by the istio provider matches the spec.
+	ServiceRouteConditionDestinationRuleSynced = "DestinationRuleSynced"
+	// ServiceRouteConditionVirtualServiceSynced tells whether the
+	// VirtualService generated by the istio provider matches the spec.
+	ServiceRouteConditionVirtualServiceSynced = "VirtualServiceSynced"
+	// ServiceRouteConditionSubsetsAvailable tells whether every subset has
+	// at least one ready endpoint.
+	ServiceRouteConditionSubsetsAvailable = "SubsetsAvailable"
+	// ServiceRouteConditionConflicted is true when an older ServiceRoute
+	// routes the same host. Conflicted ServiceRoutes are not rendered, and
+	// unlike the other conditions, it is true when something is wrong.
+	ServiceRouteConditionConflicted = "Conflicted"
+)
+
+// SubsetStatus describes the endpoints of one subset.
+type SubsetStatus struct {
+	// Name of the subset.
+	Name string `json:"name"`
+
+	// Endpoints is the number of pods of the routed Service that carry the
+	// subset labels.
+	Endpoints int32 `json:"endpoints"`
+
+	// ReadyEndpoints is the number of those pods that are ready.
+	ReadyEndpoints int32 `json:"readyEndpoints"`
 }
 
 // ServiceRouteStatus defines the observed state of ServiceRoute
 type ServiceRouteStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the generation of the spec last processed by
+	// the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the current state of the ServiceRoute.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Subsets reports the endpoints of each subset, the baseline included.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Subsets []SubsetStatus `json:"subsets,omitempty"`
+
+	// Channels lists the channels discovered on the pods of the routed
+	// Service, sorted.
+	// +optional
+	Channels []string `json:"channels,omitempty"`
+
+	// ChannelLabel is the pod label the channels were discovered by.
+	// +optional
+	ChannelLabel string `json:"channelLabel,omitempty"`
+}
+
+// available tells whether the subset has a ready endpoint, true when the
+// status does not report it.
+func (s *ServiceRouteStatus) available(name string) bool {
+	for _, subset := range s.Subsets {
+		if subset.Name == name {
+			return subset.ReadyEndpoints > 0
+		}
+	}
+	return true
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.name`
+//+kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.host`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ServiceRoute is the Schema for the serviceroutes API
 type ServiceRoute struct {
@@ -74,13 +559,80 @@ type ServiceRouteList struct {
 	Items           []ServiceRoute `json:"items"`
 }
 
+// WithDiscoveredSubsets returns the ServiceRoute with a subset appended to
+// the routes for each of channels, the values of the pod label label, when
+// discovery is enabled. Channels named like a declared subset are skipped,
+// so that declared subsets win. The ServiceRoute itself is left unchanged.
+func (c *ServiceRoute) WithDiscoveredSubsets(channels []string, label string) *ServiceRoute {
+	d := c.Spec.TrafficRoutes.Discovery
+	if d == nil || len(channels) == 0 || label == "" {
+		return c
+	}
+
+	declared := map[string]bool{}
+	for _, subset := range c.Spec.TrafficRoutes.TrafficSubset {
+		if subset != nil {
+			declared[subset.Name] = true
+		}
+	}
+	if b := c.Spec.TrafficRoutes.BaselineRoute(); b != nil {
+		declared[b.Name] = true
+	}
+
+	out := c.DeepCopy()
+	for _, channel := range channels {
+		if declared[channel] {
+			continue
+		}
+		out.Spec.TrafficRoutes.TrafficSubset = append(out.Spec.TrafficRoutes.TrafficSubset, &Subset{
+			Name:     channel,
+			Labels:   map[string]string{label: channel},
+			Headers:  map[string]*StringMatch{d.ChannelHeader(label): {Exact: channel}},
+			Fallback: d.Fallback,
+		})
+	}
+	return out
+}
+
+// GetServiceName returns the name of the routed Service.
 func (c *ServiceRoute) GetServiceName() (serviceName string) {
 	if c.Spec.Name != "" {
 		serviceName = c.Spec.Name
+	} else if key, ok := c.GetServiceKey(); ok {
+		serviceName = key.Name
 	}
 	return
 }
 
+// GetHost returns the routed host: Host when set, the name of the Service
+// otherwise.
+func (c *ServiceRoute) GetHost() string {
+	if c.Spec.Host != "" {
+		return c.Spec.Host
+	}
+	return c.Spec.Name
+}
+
+// GetServiceKey returns the Service behind the routed host. Hosts are
+// either a bare Service name, resolved in the namespace of the
+// ServiceRoute, or <name>.<namespace>.svc followed by the cluster domain.
+// It returns false for other hosts, such as ServiceEntry hosts.
+func (c *ServiceRoute) GetServiceKey() (types.NamespacedName, bool) {
+	if c.Spec.Host == "" {
+		return types.NamespacedName{Namespace: c.Namespace, Name: c.Spec.Name}, c.Spec.Name != ""
+	}
+	parts := strings.Split(c.Spec.Host, ".")
+	switch {
+	case len(parts) == 1:
+		return types.NamespacedName{Namespace: c.Namespace, Name: parts[0]}, true
+	case len(parts) >= 3 && parts[2] == "svc" && parts[0] != "*":
+		return types.NamespacedName{Namespace: parts[1], Name: parts[0]}, true
+	}
+	return types.NamespacedName{}, false
+}
+
+// +kubebuilder:validation:MinProperties=1
+// +kubebuilder:validation:MaxProperties=1
 type StringMatch struct {
 	// Specified exactly one of the fields below.
 
@@ -97,6 +649,22 @@ type StringMatch struct {
 	Regex string `json:"regex,omitempty"`
 }
 
+// AsRegex returns an RE2 expression matching the same values as m, for data
+// planes that only know exact and regex matches. Values must match the
+// expression as a whole.
+func (m *StringMatch) AsRegex() string {
+	switch {
+	case m.Regex != "":
+		return m.Regex
+	case m.Prefix != "":
+		return regexp.QuoteMeta(m.Prefix) + ".*"
+	case m.Suffix != "":
+		return ".*" + regexp.QuoteMeta(m.Suffix)
+	default:
+		return regexp.QuoteMeta(m.Exact)
+	}
+}
+
 func init() {
 	SchemeBuilder.Register(&ServiceRoute{}, &ServiceRouteList{})
 }
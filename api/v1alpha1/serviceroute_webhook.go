@@ -0,0 +1,412 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var serviceroutelog = logf.Log.WithName("serviceroute-resource")
+
+// ServiceRouteWebhook defaults and validates ServiceRoutes.
+// +kubebuilder:object:generate=false
+type ServiceRouteWebhook struct {
+	// Providers lists the mesh providers known to the controller, which
+	// spec.provider must name.
+	Providers []string
+	// DefaultProvider is set on ServiceRoutes that select no provider.
+	DefaultProvider string
+}
+
+func (w *ServiceRouteWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&ServiceRoute{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-network-kubeorbit-io-v1alpha1-serviceroute,mutating=true,failurePolicy=fail,sideEffects=None,groups=network.kubeorbit.io,resources=serviceroutes,verbs=create;update,versions=v1alpha1,name=mserviceroute.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomDefaulter = &ServiceRouteWebhook{}
+
+// Default implements admission.CustomDefaulter. It selects the default
+// provider and migrates the deprecated default map to the baseline.
+func (w *ServiceRouteWebhook) Default(_ context.Context, obj runtime.Object) error {
+	r, ok := obj.(*ServiceRoute)
+	if !ok {
+		return fmt.Errorf("expected a ServiceRoute but got a %T", obj)
+	}
+	if r.Spec.MeshProvider == "" {
+		r.Spec.MeshProvider = w.DefaultProvider
+	}
+	if r.Spec.TrafficRoutes.Baseline == nil && len(r.Spec.TrafficRoutes.Default) > 0 {
+		serviceroutelog.Info("migrate default route to baseline", "name", r.Name)
+		r.Spec.TrafficRoutes.Baseline = baselineFromDefault(r.Spec.TrafficRoutes.Default)
+		r.Spec.TrafficRoutes.Default = nil
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-network-kubeorbit-io-v1alpha1-serviceroute,mutating=false,failurePolicy=fail,sideEffects=None,groups=network.kubeorbit.io,resources=serviceroutes,verbs=create;update,versions=v1alpha1,name=vserviceroute.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomValidator = &ServiceRouteWebhook{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (w *ServiceRouteWebhook) ValidateCreate(_ context.Context, obj runtime.Object) error {
+	r, ok := obj.(*ServiceRoute)
+	if !ok {
+		return fmt.Errorf("expected a ServiceRoute but got a %T", obj)
+	}
+	serviceroutelog.Info("validate create", "name", r.Name)
+	return r.validate(w.Providers)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (w *ServiceRouteWebhook) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) error {
+	r, ok := newObj.(*ServiceRoute)
+	if !ok {
+		return fmt.Errorf("expected a ServiceRoute but got a %T", newObj)
+	}
+	serviceroutelog.Info("validate update", "name", r.Name)
+	// Objects admitted before a rule was added must still let the
+	// controller update their finalizers.
+	if o, ok := oldObj.(*ServiceRoute); ok && equality.Semantic.DeepEqual(o.Spec, r.Spec) {
+		return nil
+	}
+	return r.validate(w.Providers)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (w *ServiceRouteWebhook) ValidateDelete(_ context.Context, _ runtime.Object) error {
+	return nil
+}
+
+func (r *ServiceRoute) validate(providers []string) error {
+	var errs field.ErrorList
+	errs = append(errs, r.validateHost()...)
+	errs = append(errs, validateProvider(field.NewPath("spec", "provider"), r.Spec.MeshProvider, providers)...)
+	trafficRoutes := field.NewPath("spec", "trafficRoutes")
+	routes := trafficRoutes.Child("routes")
+
+	// subsets holds the subsets traffic can be sent to, the ones with labels.
+	subsets := map[string]bool{}
+	names := map[string]bool{}
+	for i, subset := range r.Spec.TrafficRoutes.TrafficSubset {
+		if subset == nil {
+			errs = append(errs, field.Required(routes.Index(i), "route must not be empty"))
+			continue
+		}
+		errs = append(errs, validateSubsetName(routes.Index(i).Child("name"), subset.Name)...)
+		if names[subset.Name] {
+			errs = append(errs, field.Duplicate(routes.Index(i).Child("name"), subset.Name))
+		}
+		names[subset.Name] = true
+		if subset.Labels != nil {
+			subsets[subset.Name] = true
+		}
+	}
+	baseline := r.Spec.TrafficRoutes.BaselineRoute()
+	if r.Spec.TrafficRoutes.Baseline != nil && len(r.Spec.TrafficRoutes.Default) > 0 {
+		errs = append(errs, field.Forbidden(trafficRoutes.Child("default"), "default is deprecated and may not be set with baseline"))
+	}
+	if s := baseline.Subset(); s != nil {
+		if r.Spec.TrafficRoutes.Baseline != nil {
+			errs = append(errs, validateSubsetName(trafficRoutes.Child("baseline", "name"), s.Name)...)
+		}
+		if names[s.Name] {
+			errs = append(errs, field.Duplicate(trafficRoutes.Child("baseline", "name"), s.Name))
+		}
+		subsets[s.Name] = true
+	}
+
+	for i, subset := range r.Spec.TrafficRoutes.TrafficSubset {
+		if subset == nil {
+			continue
+		}
+		errs = append(errs, validateWeights(routes.Index(i).Child("weights"), subset.Weights, subsets)...)
+		errs = append(errs, validateRoutePolicy(routes.Index(i), &subset.RoutePolicy)...)
+		headers := routes.Index(i).Child("headers")
+		for name, match := range subset.Headers {
+			errs = append(errs, validateHeaderName(headers.Key(name), name)...)
+			errs = append(errs, validateStringMatch(headers.Key(name), match)...)
+		}
+		if subset.Labels == nil && len(subset.Weights) == 0 && (len(subset.Headers) > 0 || subset.Match != nil) {
+			errs = append(errs, field.Required(routes.Index(i).Child("labels"),
+				"the route matches requests but sends them nowhere: set labels or weights"))
+		}
+		if subset.Match != nil {
+			errs = append(errs, validateHTTPMatch(routes.Index(i).Child("match"), subset)...)
+		}
+	}
+	if baseline != nil {
+		errs = append(errs, validateBaseline(trafficRoutes.Child("baseline"), baseline, subsets)...)
+		errs = append(errs, validateRoutePolicy(trafficRoutes.Child("baseline"), &baseline.RoutePolicy)...)
+	}
+	if m := r.Spec.TrafficRoutes.Mirror; m != nil {
+		mirror := trafficRoutes.Child("mirror")
+		switch {
+		case baseline == nil:
+			errs = append(errs, field.Forbidden(mirror, "mirror copies the traffic of the baseline, which must be set"))
+		case !subsets[m.Subset] && r.Spec.TrafficRoutes.Discovery == nil:
+			errs = append(errs, field.NotFound(mirror.Child("subset"), m.Subset))
+		}
+		if p := m.MirrorPercentage(); p < 0 || p > 100 {
+			errs = append(errs, field.Invalid(mirror.Child("percentage"), p, "must be between 0 and 100"))
+		}
+	}
+	if r.Spec.Merge != nil {
+		errs = append(errs, validateMergedBaseline(trafficRoutes, &r.Spec.TrafficRoutes)...)
+	}
+	if d := r.Spec.TrafficRoutes.Discovery; d != nil {
+		if d.Label != "" {
+			for _, msg := range validation.IsQualifiedName(d.Label) {
+				errs = append(errs, field.Invalid(trafficRoutes.Child("discovery", "label"), d.Label, msg))
+			}
+		}
+		if d.Header != "" {
+			errs = append(errs, validateHeaderName(trafficRoutes.Child("discovery", "header"), d.Header)...)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ServiceRoute").GroupKind(), r.Name, errs)
+}
+
+// validateHost checks that the ServiceRoute names one host, and the
+// namespaces its routes are exported to.
+func (r *ServiceRoute) validateHost() field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	switch {
+	case r.Spec.Name == "" && r.Spec.Host == "":
+		errs = append(errs, field.Required(spec.Child("name"), "one of name or host must be set"))
+	case r.Spec.Name != "" && r.Spec.Host != "":
+		errs = append(errs, field.Forbidden(spec.Child("host"), "may not be set with name"))
+	case r.Spec.Host != "":
+		host := r.Spec.Host
+		check := validation.IsDNS1123Subdomain
+		if strings.HasPrefix(host, "*.") {
+			check = validation.IsWildcardDNS1123Subdomain
+		}
+		for _, msg := range check(host) {
+			errs = append(errs, field.Invalid(spec.Child("host"), host, msg))
+		}
+	}
+	if m := r.Spec.Merge; m != nil {
+		for _, msg := range validation.IsDNS1123Subdomain(m.VirtualService) {
+			errs = append(errs, field.Invalid(spec.Child("merge", "virtualService"), m.VirtualService, msg))
+		}
+	}
+	for i, ns := range r.Spec.ExportTo {
+		if ns == "." || ns == "*" {
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(spec.Child("exportTo").Index(i), ns, msg))
+		}
+	}
+	return errs
+}
+
+// validateSubsetName checks that name can name the Services and mesh
+// subsets generated for the subset.
+func validateSubsetName(path *field.Path, name string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(path, "subsets must be named")}
+	}
+	var errs field.ErrorList
+	for _, msg := range validation.IsDNS1123Label(name) {
+		errs = append(errs, field.Invalid(path, name, msg))
+	}
+	return errs
+}
+
+// validateBaseline checks that the baseline sends the traffic somewhere
+// known: to its own subset, a subset of the routes, or weighted subsets.
+func validateBaseline(path *field.Path, baseline *BaselineRoute, subsets map[string]bool) field.ErrorList {
+	switch {
+	case baseline.Labels != nil && baseline.Name == "":
+		return field.ErrorList{field.Required(path.Child("name"), "labels define a subset, which needs a name")}
+	case len(baseline.Weights) > 0:
+		return validateWeights(path.Child("weights"), baseline.Weights, subsets)
+	case baseline.Name == "":
+		return field.ErrorList{field.Required(path.Child("name"), "one of name or weights must be set")}
+	case !subsets[baseline.Name]:
+		return field.ErrorList{field.NotFound(path.Child("name"), baseline.Name)}
+	}
+	return nil
+}
+
+// validateMergedBaseline rejects the fields of the baseline a merge leaves
+// out: the routes of the merged VirtualService take its traffic.
+func validateMergedBaseline(path *field.Path, routes *TrafficRouteSpec) field.ErrorList {
+	const msg = "may not be set with merge, the merged VirtualService routes the traffic of the baseline"
+	var errs field.ErrorList
+	if routes.Mirror != nil {
+		errs = append(errs, field.Forbidden(path.Child("mirror"), msg))
+	}
+	if b := routes.Baseline; b != nil {
+		baseline := path.Child("baseline")
+		if b.Timeout != nil {
+			errs = append(errs, field.Forbidden(baseline.Child("timeout"), msg))
+		}
+		if b.Retries != nil {
+			errs = append(errs, field.Forbidden(baseline.Child("retries"), msg))
+		}
+		if b.Fault != nil {
+			errs = append(errs, field.Forbidden(baseline.Child("fault"), msg))
+		}
+	}
+	return errs
+}
+
+// validateWeights checks that weighted destinations name known subsets and
+// share the whole traffic.
+func validateWeights(path *field.Path, weights []WeightedDestination, subsets map[string]bool) field.ErrorList {
+	if len(weights) == 0 {
+		return nil
+	}
+
+	var errs field.ErrorList
+	total := int32(0)
+	for i, w := range weights {
+		if !subsets[w.Subset] {
+			errs = append(errs, field.NotFound(path.Index(i).Child("subset"), w.Subset))
+		}
+		if w.Weight < 0 || w.Weight > 100 {
+			errs = append(errs, field.Invalid(path.Index(i).Child("weight"), w.Weight, "must be between 0 and 100"))
+		}
+		total += w.Weight
+	}
+	if total != 100 {
+		errs = append(errs, field.Invalid(path, total, "weights must sum to 100"))
+	}
+	return errs
+}
+
+// validateRoutePolicy checks that durations are positive and that the
+// retries and faults are in range.
+func validateRoutePolicy(path *field.Path, policy *RoutePolicy) field.ErrorList {
+	var errs field.ErrorList
+	if policy.Timeout != nil && policy.Timeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("timeout"), policy.Timeout.Duration.String(), "must be positive"))
+	}
+	if r := policy.Retries; r != nil {
+		if r.Attempts < 0 {
+			errs = append(errs, field.Invalid(path.Child("retries", "attempts"), r.Attempts, "must not be negative"))
+		}
+		if r.PerTryTimeout != nil && r.PerTryTimeout.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child("retries", "perTryTimeout"), r.PerTryTimeout.Duration.String(), "must be positive"))
+		}
+	}
+	if f := policy.Fault; f != nil {
+		fault := path.Child("fault")
+		if f.Delay == nil && f.Abort == nil {
+			errs = append(errs, field.Required(fault, "one of delay or abort must be set"))
+		}
+		if d := f.Delay; d != nil {
+			if d.FixedDelay.Duration <= 0 {
+				errs = append(errs, field.Invalid(fault.Child("delay", "fixedDelay"), d.FixedDelay.Duration.String(), "must be positive"))
+			}
+			if p := d.DelayPercentage(); p < 0 || p > 100 {
+				errs = append(errs, field.Invalid(fault.Child("delay", "percentage"), p, "must be between 0 and 100"))
+			}
+		}
+		if a := f.Abort; a != nil {
+			if a.HTTPStatus < 200 || a.HTTPStatus > 599 {
+				errs = append(errs, field.Invalid(fault.Child("abort", "httpStatus"), a.HTTPStatus, "must be between 200 and 599"))
+			}
+			if p := a.AbortPercentage(); p < 0 || p > 100 {
+				errs = append(errs, field.Invalid(fault.Child("abort", "percentage"), p, "must be between 0 and 100"))
+			}
+		}
+	}
+	return errs
+}
+
+func validateHTTPMatch(path *field.Path, subset *Subset) field.ErrorList {
+	var errs field.ErrorList
+	match := subset.Match
+	if match.URI != nil {
+		errs = append(errs, validateStringMatch(path.Child("uri"), match.URI)...)
+	}
+	if match.Method != nil {
+		errs = append(errs, validateStringMatch(path.Child("method"), match.Method)...)
+	}
+	if match.Authority != nil {
+		errs = append(errs, validateStringMatch(path.Child("authority"), match.Authority)...)
+	}
+	for k, m := range match.Headers {
+		errs = append(errs, validateHeaderName(path.Child("headers").Key(k), k)...)
+		errs = append(errs, validateStringMatch(path.Child("headers").Key(k), m)...)
+		for h := range subset.Headers {
+			if strings.EqualFold(k, h) {
+				errs = append(errs, field.Duplicate(path.Child("headers").Key(k), k))
+			}
+		}
+	}
+	for k, m := range match.QueryParams {
+		errs = append(errs, validateStringMatch(path.Child("queryParams").Key(k), m)...)
+	}
+	for k, m := range match.WithoutHeaders {
+		errs = append(errs, validateHeaderName(path.Child("withoutHeaders").Key(k), k)...)
+		errs = append(errs, validateStringMatch(path.Child("withoutHeaders").Key(k), m)...)
+	}
+	return errs
+}
+
+// validateStringMatch checks that exactly one match type is set, and that
+// regexes compile.
+func validateStringMatch(path *field.Path, match *StringMatch) field.ErrorList {
+	if match == nil {
+		return field.ErrorList{field.Required(path, "one of exact, prefix, suffix or regex must be set")}
+	}
+
+	set := 0
+	for _, v := range []string{match.Exact, match.Prefix, match.Suffix, match.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+	switch {
+	case set == 0:
+		return field.ErrorList{field.Required(path, "one of exact, prefix, suffix or regex must be set")}
+	case set > 1:
+		return field.ErrorList{field.Invalid(path, match, "only one of exact, prefix, suffix or regex may be set")}
+	}
+
+	if match.Regex != "" {
+		if _, err := regexp.Compile(match.Regex); err != nil {
+			return field.ErrorList{field.Invalid(path.Child("regex"), match.Regex, err.Error())}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"errors"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validateProvider checks that provider names one of the known mesh
+// providers. It fails closed when the webhook was set up without any.
+func validateProvider(path *field.Path, provider string, known []string) field.ErrorList {
+	if len(known) == 0 {
+		return field.ErrorList{field.InternalError(path, errors.New("the webhook knows no mesh provider"))}
+	}
+	if provider == "" {
+		return field.ErrorList{field.Required(path, "no mesh provider is selected and the controller has no default provider")}
+	}
+	for _, p := range known {
+		if p == provider {
+			return nil
+		}
+	}
+	return field.ErrorList{field.NotSupported(path, provider, known)}
+}
+
+// validateHeaderName checks that name is an RFC 7230 token, the grammar of
+// header field names.
+func validateHeaderName(path *field.Path, name string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(path, "header name must not be empty")}
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return field.ErrorList{field.Invalid(path, name,
+				"header names are RFC 7230 tokens: letters, digits and !#$%&'*+-.^_`|~")}
+		}
+	}
+	return nil
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// validateHeaderValue checks that value is an RFC 7230 field value:
+// visible characters, spaces and tabs.
+func validateHeaderValue(path *field.Path, value string) field.ErrorList {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c < 0x20 && c != '\t' || c == 0x7f {
+			return field.ErrorList{field.Invalid(path, value, "header values must not hold control characters")}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testProviders = []string{"istio", "none"}
+
+// invalidFields returns the sorted fields an error returned by validate
+// points at, nil when there is no error.
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	status, ok := err.(*apierrors.StatusError)
+	if !ok || status.ErrStatus.Details == nil {
+		t.Fatalf("expected an Invalid status error, got %v", err)
+	}
+	var fields []string
+	for _, cause := range status.ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestOrbitValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Orbit)
+		want   []string
+	}{
+		{name: "valid", mutate: func(*Orbit) {}},
+		{
+			name:   "missing provider",
+			mutate: func(o *Orbit) { o.Spec.MeshProvider = "" },
+			want:   []string{"spec.provider"},
+		},
+		{
+			name:   "unknown provider",
+			mutate: func(o *Orbit) { o.Spec.MeshProvider = "cilium" },
+			want:   []string{"spec.provider"},
+		},
+		{
+			name:   "invalid header name",
+			mutate: func(o *Orbit) { o.Spec.TrafficRules.Propagate[0].Name = "x tenant" },
+			want:   []string{"spec.trafficRules.propagate[0].name"},
+		},
+		{
+			name: "header in map and rules",
+			mutate: func(o *Orbit) {
+				o.Spec.TrafficRules.Headers = map[string]string{"X-Tenant": ""}
+			},
+		},
+		{
+			name: "duplicate map header",
+			mutate: func(o *Orbit) {
+				o.Spec.TrafficRules.Headers = map[string]string{"version": "", "Version": ""}
+			},
+			want: []string{"spec.trafficRules.headers[version]"},
+		},
+		{
+			name: "duplicate rule",
+			mutate: func(o *Orbit) {
+				o.Spec.TrafficRules.Propagate[1].Name = "X-Tenant"
+			},
+			want: []string{"spec.trafficRules.propagate[1].name"},
+		},
+		{
+			name:   "control character in value",
+			mutate: func(o *Orbit) { o.Spec.TrafficRules.Propagate[1].Value = "a\nb" },
+			want:   []string{"spec.trafficRules.propagate[1].value"},
+		},
+		{
+			name:   "invalid env",
+			mutate: func(o *Orbit) { o.Spec.TrafficRules.Propagate[0].Env = "1TENANT=" },
+			want:   []string{"spec.trafficRules.propagate[0].env"},
+		},
+		{
+			name: "invalid workload selector",
+			mutate: func(o *Orbit) {
+				o.Spec.WorkloadSelector = &WorkloadSelector{Labels: map[string]string{"app": "not valid"}}
+			},
+			want: []string{"spec.workloadSelector.labels"},
+		},
+		{
+			name: "invalid container name",
+			mutate: func(o *Orbit) {
+				o.Spec.ApplicationEnv = &ApplicationEnvSpec{Containers: []string{"app", "App_1"}}
+			},
+			want: []string{"spec.applicationEnv.containers[1]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Orbit{
+				ObjectMeta: metav1.ObjectMeta{Name: "orbit", Namespace: "apps"},
+				Spec: OrbitSpec{
+					MeshProvider: "istio",
+					TrafficRules: TrafficRulesSpec{Propagate: []HeaderPropagation{
+						{Name: "x-tenant", Env: "TENANT_ID"},
+						{Name: "x-caller", Value: "apps"},
+					}},
+				},
+			}
+			tt.mutate(o)
+			if got := invalidFields(t, o.validate(testProviders)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrbitValidateWithoutProviders(t *testing.T) {
+	o := &Orbit{Spec: OrbitSpec{MeshProvider: "istio"}}
+	if got := invalidFields(t, o.validate(nil)); !reflect.DeepEqual(got, []string{"spec.provider"}) {
+		t.Errorf("invalid fields = %v, want the provider to be rejected", got)
+	}
+}
+
+func TestServiceRouteValidate(t *testing.T) {
+	percentage := func(p int32) *int32 { return &p }
+	tests := []struct {
+		name   string
+		mutate func(*ServiceRoute)
+		want   []string
+	}{
+		{name: "valid", mutate: func(*ServiceRoute) {}},
+		{
+			name:   "unknown provider",
+			mutate: func(r *ServiceRoute) { r.Spec.MeshProvider = "cilium" },
+			want:   []string{"spec.provider"},
+		},
+		{
+			name:   "no host",
+			mutate: func(r *ServiceRoute) { r.Spec.Name = "" },
+			want:   []string{"spec.name"},
+		},
+		{
+			name:   "name and host",
+			mutate: func(r *ServiceRoute) { r.Spec.Host = "reviews.apps.svc.cluster.local" },
+			want:   []string{"spec.host"},
+		},
+		{
+			name: "wildcard host",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.Name = ""
+				r.Spec.Host = "*.apps.svc.cluster.local"
+			},
+		},
+		{
+			name:   "invalid exportTo",
+			mutate: func(r *ServiceRoute) { r.Spec.ExportTo = []string{".", "*", "Apps"} },
+			want:   []string{"spec.exportTo[2]"},
+		},
+		{
+			name: "nil route",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset = append(r.Spec.TrafficRoutes.TrafficSubset, nil)
+			},
+			want: []string{"spec.trafficRoutes.routes[1]"},
+		},
+		{
+			name:   "invalid subset name",
+			mutate: func(r *ServiceRoute) { r.Spec.TrafficRoutes.TrafficSubset[0].Name = "Dev" },
+			want:   []string{"spec.trafficRoutes.routes[0].name"},
+		},
+		{
+			name: "duplicate subset name",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.Baseline = &BaselineRoute{Name: "dev", Labels: map[string]string{"version": "base"}}
+			},
+			want: []string{"spec.trafficRoutes.baseline.name"},
+		},
+		{
+			name: "default with baseline",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.Default = map[string]string{"version": "base"}
+			},
+			want: []string{"spec.trafficRoutes.default"},
+		},
+		{
+			name: "deprecated default",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.Baseline = nil
+				r.Spec.TrafficRoutes.Default = map[string]string{"version": "base"}
+			},
+		},
+		{
+			name:   "unknown baseline subset",
+			mutate: func(r *ServiceRoute) { r.Spec.TrafficRoutes.Baseline = &BaselineRoute{Name: "prod"} },
+			want:   []string{"spec.trafficRoutes.baseline.name"},
+		},
+		{
+			name: "weights not summing to 100",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Weights = []WeightedDestination{
+					{Subset: "dev", Weight: 50}, {Subset: "base", Weight: 40},
+				}
+			},
+			want: []string{"spec.trafficRoutes.routes[0].weights"},
+		},
+		{
+			name: "weight to unknown subset",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Weights = []WeightedDestination{
+					{Subset: "dev", Weight: 50}, {Subset: "prod", Weight: 50},
+				}
+			},
+			want: []string{"spec.trafficRoutes.routes[0].weights[1].subset"},
+		},
+		{
+			name: "match sending nowhere",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Labels = nil
+				r.Spec.TrafficRoutes.Baseline.Labels = map[string]string{"version": "base"}
+			},
+			want: []string{"spec.trafficRoutes.routes[0].labels"},
+		},
+		{
+			name: "two match types",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Match.Headers["version"].Prefix = "d"
+			},
+			want: []string{"spec.trafficRoutes.routes[0].match.headers[version]"},
+		},
+		{
+			name: "invalid regex",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Match.URI = &StringMatch{Regex: "/api/("}
+			},
+			want: []string{"spec.trafficRoutes.routes[0].match.uri.regex"},
+		},
+		{
+			name: "header in headers and match",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Headers = map[string]*StringMatch{"Version": {Exact: "dev"}}
+			},
+			want: []string{"spec.trafficRoutes.routes[0].match.headers[version]"},
+		},
+		{
+			name: "invalid header name",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Match.WithoutHeaders = map[string]*StringMatch{"x:debug": {Exact: "1"}}
+			},
+			want: []string{"spec.trafficRoutes.routes[0].match.withoutHeaders[x:debug]"},
+		},
+		{
+			name: "invalid policy",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.TrafficSubset[0].Timeout = &metav1.Duration{Duration: -time.Second}
+				r.Spec.TrafficRoutes.TrafficSubset[0].Fault = &HTTPFaultInjection{
+					Abort: &FaultAbort{HTTPStatus: 600, Percentage: percentage(101)},
+				}
+			},
+			want: []string{
+				"spec.trafficRoutes.routes[0].fault.abort.httpStatus",
+				"spec.trafficRoutes.routes[0].fault.abort.percentage",
+				"spec.trafficRoutes.routes[0].timeout",
+			},
+		},
+		{
+			name: "mirror to unknown subset",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.Mirror = &MirrorRoute{Subset: "prod", Percentage: percentage(-1)}
+			},
+			want: []string{"spec.trafficRoutes.mirror.percentage", "spec.trafficRoutes.mirror.subset"},
+		},
+		{
+			name: "mirror without baseline",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.Baseline = nil
+				r.Spec.TrafficRoutes.Mirror = &MirrorRoute{Subset: "dev"}
+			},
+			want: []string{"spec.trafficRoutes.mirror"},
+		},
+		{
+			name: "baseline mirror and policy with merge",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.Merge = &MergeSpec{VirtualService: "reviews"}
+				r.Spec.TrafficRoutes.Mirror = &MirrorRoute{Subset: "dev"}
+				r.Spec.TrafficRoutes.Baseline.Timeout = &metav1.Duration{Duration: time.Second}
+				r.Spec.TrafficRoutes.Baseline.Retries = &HTTPRetry{Attempts: 3}
+			},
+			want: []string{
+				"spec.trafficRoutes.baseline.retries",
+				"spec.trafficRoutes.baseline.timeout",
+				"spec.trafficRoutes.mirror",
+			},
+		},
+		{
+			name: "subset policy with merge",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.Merge = &MergeSpec{VirtualService: "reviews"}
+				r.Spec.TrafficRoutes.TrafficSubset[0].Timeout = &metav1.Duration{Duration: time.Second}
+			},
+		},
+		{
+			name: "invalid discovery",
+			mutate: func(r *ServiceRoute) {
+				r.Spec.TrafficRoutes.Discovery = &DiscoverySpec{Label: "-version", Header: "x channel"}
+			},
+			want: []string{"spec.trafficRoutes.discovery.header", "spec.trafficRoutes.discovery.label"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ServiceRoute{
+				ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "apps"},
+				Spec: ServiceRouteSpec{
+					Name:         "reviews",
+					MeshProvider: "istio",
+					TrafficRoutes: TrafficRouteSpec{
+						TrafficSubset: []*Subset{{
+							Name:   "dev",
+							Labels: map[string]string{"version": "dev"},
+							Match:  &HTTPMatchRequest{Headers: map[string]*StringMatch{"version": {Exact: "dev"}}},
+						}},
+						Baseline: &BaselineRoute{Name: "base", Labels: map[string]string{"version": "base"}},
+					},
+				},
+			}
+			tt.mutate(r)
+			if got := invalidFields(t, r.validate(testProviders)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
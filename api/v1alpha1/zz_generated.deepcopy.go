@@ -22,12 +22,158 @@ limitations under the License.
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ApplicationEnvSpec) DeepCopyInto(out *ApplicationEnvSpec) {
+	*out = *in
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationEnvSpec.
+func (in *ApplicationEnvSpec) DeepCopy() *ApplicationEnvSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ApplicationEnvSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BaselineRoute) DeepCopyInto(out *BaselineRoute) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Weights != nil {
+		in, out := &in.Weights, &out.Weights
+		*out = make([]WeightedDestination, len(*in))
+		copy(*out, *in)
+	}
+	in.RoutePolicy.DeepCopyInto(&out.RoutePolicy)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BaselineRoute.
+func (in *BaselineRoute) DeepCopy() *BaselineRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(BaselineRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscoverySpec) DeepCopyInto(out *DiscoverySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoverySpec.
+func (in *DiscoverySpec) DeepCopy() *DiscoverySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscoverySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FaultAbort) DeepCopyInto(out *FaultAbort) {
+	*out = *in
+	if in.Percentage != nil {
+		in, out := &in.Percentage, &out.Percentage
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FaultAbort.
+func (in *FaultAbort) DeepCopy() *FaultAbort {
+	if in == nil {
+		return nil
+	}
+	out := new(FaultAbort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FaultDelay) DeepCopyInto(out *FaultDelay) {
+	*out = *in
+	out.FixedDelay = in.FixedDelay
+	if in.Percentage != nil {
+		in, out := &in.Percentage, &out.Percentage
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FaultDelay.
+func (in *FaultDelay) DeepCopy() *FaultDelay {
+	if in == nil {
+		return nil
+	}
+	out := new(FaultDelay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPFaultInjection) DeepCopyInto(out *HTTPFaultInjection) {
+	*out = *in
+	if in.Delay != nil {
+		in, out := &in.Delay, &out.Delay
+		*out = new(FaultDelay)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Abort != nil {
+		in, out := &in.Abort, &out.Abort
+		*out = new(FaultAbort)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPFaultInjection.
+func (in *HTTPFaultInjection) DeepCopy() *HTTPFaultInjection {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPFaultInjection)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTPMatchRequest) DeepCopyInto(out *HTTPMatchRequest) {
 	*out = *in
+	if in.URI != nil {
+		in, out := &in.URI, &out.URI
+		*out = new(StringMatch)
+		**out = **in
+	}
+	if in.Method != nil {
+		in, out := &in.Method, &out.Method
+		*out = new(StringMatch)
+		**out = **in
+	}
+	if in.Authority != nil {
+		in, out := &in.Authority, &out.Authority
+		*out = new(StringMatch)
+		**out = **in
+	}
 	if in.Headers != nil {
 		in, out := &in.Headers, &out.Headers
 		*out = make(map[string]*StringMatch, len(*in))
@@ -43,6 +189,36 @@ func (in *HTTPMatchRequest) DeepCopyInto(out *HTTPMatchRequest) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.QueryParams != nil {
+		in, out := &in.QueryParams, &out.QueryParams
+		*out = make(map[string]*StringMatch, len(*in))
+		for key, val := range *in {
+			var outVal *StringMatch
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = new(StringMatch)
+				**out = **in
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.WithoutHeaders != nil {
+		in, out := &in.WithoutHeaders, &out.WithoutHeaders
+		*out = make(map[string]*StringMatch, len(*in))
+		for key, val := range *in {
+			var outVal *StringMatch
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = new(StringMatch)
+				**out = **in
+			}
+			(*out)[key] = outVal
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPMatchRequest.
@@ -55,13 +231,83 @@ func (in *HTTPMatchRequest) DeepCopy() *HTTPMatchRequest {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPRetry) DeepCopyInto(out *HTTPRetry) {
+	*out = *in
+	if in.PerTryTimeout != nil {
+		in, out := &in.PerTryTimeout, &out.PerTryTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPRetry.
+func (in *HTTPRetry) DeepCopy() *HTTPRetry {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPRetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HeaderPropagation) DeepCopyInto(out *HeaderPropagation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HeaderPropagation.
+func (in *HeaderPropagation) DeepCopy() *HeaderPropagation {
+	if in == nil {
+		return nil
+	}
+	out := new(HeaderPropagation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MergeSpec) DeepCopyInto(out *MergeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MergeSpec.
+func (in *MergeSpec) DeepCopy() *MergeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MergeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MirrorRoute) DeepCopyInto(out *MirrorRoute) {
+	*out = *in
+	if in.Percentage != nil {
+		in, out := &in.Percentage, &out.Percentage
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MirrorRoute.
+func (in *MirrorRoute) DeepCopy() *MirrorRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(MirrorRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Orbit) DeepCopyInto(out *Orbit) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Orbit.
@@ -118,6 +364,16 @@ func (in *OrbitList) DeepCopyObject() runtime.Object {
 func (in *OrbitSpec) DeepCopyInto(out *OrbitSpec) {
 	*out = *in
 	in.TrafficRules.DeepCopyInto(&out.TrafficRules)
+	if in.WorkloadSelector != nil {
+		in, out := &in.WorkloadSelector, &out.WorkloadSelector
+		*out = new(WorkloadSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ApplicationEnv != nil {
+		in, out := &in.ApplicationEnv, &out.ApplicationEnv
+		*out = new(ApplicationEnvSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrbitSpec.
@@ -133,6 +389,18 @@ func (in *OrbitSpec) DeepCopy() *OrbitSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OrbitStatus) DeepCopyInto(out *OrbitStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EnvoyFilterRef != nil {
+		in, out := &in.EnvoyFilterRef, &out.EnvoyFilterRef
+		*out = new(corev1.ObjectReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrbitStatus.
@@ -145,13 +413,43 @@ func (in *OrbitStatus) DeepCopy() *OrbitStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutePolicy) DeepCopyInto(out *RoutePolicy) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(HTTPRetry)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fault != nil {
+		in, out := &in.Fault, &out.Fault
+		*out = new(HTTPFaultInjection)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutePolicy.
+func (in *RoutePolicy) DeepCopy() *RoutePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceRoute) DeepCopyInto(out *ServiceRoute) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceRoute.
@@ -208,6 +506,16 @@ func (in *ServiceRouteList) DeepCopyObject() runtime.Object {
 func (in *ServiceRouteSpec) DeepCopyInto(out *ServiceRouteSpec) {
 	*out = *in
 	in.TrafficRoutes.DeepCopyInto(&out.TrafficRoutes)
+	if in.ExportTo != nil {
+		in, out := &in.ExportTo, &out.ExportTo
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Merge != nil {
+		in, out := &in.Merge, &out.Merge
+		*out = new(MergeSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceRouteSpec.
@@ -223,6 +531,23 @@ func (in *ServiceRouteSpec) DeepCopy() *ServiceRouteSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceRouteStatus) DeepCopyInto(out *ServiceRouteStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Subsets != nil {
+		in, out := &in.Subsets, &out.Subsets
+		*out = make([]SubsetStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Channels != nil {
+		in, out := &in.Channels, &out.Channels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceRouteStatus.
@@ -275,6 +600,17 @@ func (in *Subset) DeepCopyInto(out *Subset) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.Match != nil {
+		in, out := &in.Match, &out.Match
+		*out = new(HTTPMatchRequest)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Weights != nil {
+		in, out := &in.Weights, &out.Weights
+		*out = make([]WeightedDestination, len(*in))
+		copy(*out, *in)
+	}
+	in.RoutePolicy.DeepCopyInto(&out.RoutePolicy)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Subset.
@@ -287,6 +623,21 @@ func (in *Subset) DeepCopy() *Subset {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubsetStatus) DeepCopyInto(out *SubsetStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubsetStatus.
+func (in *SubsetStatus) DeepCopy() *SubsetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SubsetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TrafficRouteSpec) DeepCopyInto(out *TrafficRouteSpec) {
 	*out = *in
@@ -301,6 +652,11 @@ func (in *TrafficRouteSpec) DeepCopyInto(out *TrafficRouteSpec) {
 			}
 		}
 	}
+	if in.Baseline != nil {
+		in, out := &in.Baseline, &out.Baseline
+		*out = new(BaselineRoute)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Default != nil {
 		in, out := &in.Default, &out.Default
 		*out = make(map[string]string, len(*in))
@@ -308,6 +664,16 @@ func (in *TrafficRouteSpec) DeepCopyInto(out *TrafficRouteSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.Discovery != nil {
+		in, out := &in.Discovery, &out.Discovery
+		*out = new(DiscoverySpec)
+		**out = **in
+	}
+	if in.Mirror != nil {
+		in, out := &in.Mirror, &out.Mirror
+		*out = new(MirrorRoute)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficRouteSpec.
@@ -330,6 +696,11 @@ func (in *TrafficRulesSpec) DeepCopyInto(out *TrafficRulesSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.Propagate != nil {
+		in, out := &in.Propagate, &out.Propagate
+		*out = make([]HeaderPropagation, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficRulesSpec.
@@ -341,3 +712,40 @@ func (in *TrafficRulesSpec) DeepCopy() *TrafficRulesSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeightedDestination) DeepCopyInto(out *WeightedDestination) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeightedDestination.
+func (in *WeightedDestination) DeepCopy() *WeightedDestination {
+	if in == nil {
+		return nil
+	}
+	out := new(WeightedDestination)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadSelector) DeepCopyInto(out *WorkloadSelector) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadSelector.
+func (in *WorkloadSelector) DeepCopy() *WorkloadSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadSelector)
+	in.DeepCopyInto(out)
+	return out
+}
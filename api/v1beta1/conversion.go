@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conversionDataAnnotation keeps the v1alpha1 spec of objects using fields
+// v1beta1 has no room for, such as the deprecated header maps, so that
+// converting them back to v1alpha1 loses nothing.
+const conversionDataAnnotation = "network.kubeorbit.io/conversion-data"
+
+// marshalData stores the hub spec in the annotations of meta.
+func marshalData(meta *metav1.ObjectMeta, spec interface{}) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the conversion data: %w", err)
+	}
+	annotations := make(map[string]string, len(meta.Annotations)+1)
+	for k, v := range meta.Annotations {
+		annotations[k] = v
+	}
+	annotations[conversionDataAnnotation] = string(data)
+	meta.Annotations = annotations
+	return nil
+}
+
+// unmarshalData removes the hub spec from the annotations of meta and
+// decodes it into spec. It returns false when there is none.
+func unmarshalData(meta *metav1.ObjectMeta, spec interface{}) (bool, error) {
+	data, ok := meta.Annotations[conversionDataAnnotation]
+	if !ok {
+		return false, nil
+	}
+	var annotations map[string]string
+	if len(meta.Annotations) > 1 {
+		annotations = make(map[string]string, len(meta.Annotations)-1)
+		for k, v := range meta.Annotations {
+			if k != conversionDataAnnotation {
+				annotations[k] = v
+			}
+		}
+	}
+	meta.Annotations = annotations
+	if err := json.Unmarshal([]byte(data), spec); err != nil {
+		return false, fmt.Errorf("failed to unmarshal the conversion data: %w", err)
+	}
+	return true, nil
+}
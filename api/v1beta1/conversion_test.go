@@ -0,0 +1,239 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"kubeorbit.io/api/v1alpha1"
+)
+
+const fuzzIterations = 200
+
+// newFuzzer fills objects but their TypeMeta, which the API server sets
+// after conversion.
+func newFuzzer() *fuzz.Fuzzer {
+	return fuzz.New().NilChance(0.2).Funcs(
+		func(*metav1.TypeMeta, fuzz.Continue) {},
+	)
+}
+
+func TestOrbitRoundTrip(t *testing.T) {
+	f := newFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		in := &Orbit{}
+		f.Fuzz(in)
+		hub := &v1alpha1.Orbit{}
+		if err := in.ConvertTo(hub); err != nil {
+			t.Fatalf("ConvertTo: %v", err)
+		}
+		out := &Orbit{}
+		if err := out.ConvertFrom(hub); err != nil {
+			t.Fatalf("ConvertFrom: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(in, out) {
+			t.Fatalf("v1beta1 round trip changed the Orbit:\n%s", diff.ObjectReflectDiff(in, out))
+		}
+	}
+
+	f = newFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		in := &v1alpha1.Orbit{}
+		f.Fuzz(in)
+		spoke := &Orbit{}
+		if err := spoke.ConvertFrom(in); err != nil {
+			t.Fatalf("ConvertFrom: %v", err)
+		}
+		out := &v1alpha1.Orbit{}
+		if err := spoke.ConvertTo(out); err != nil {
+			t.Fatalf("ConvertTo: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(in, out) {
+			t.Fatalf("v1alpha1 round trip changed the Orbit:\n%s", diff.ObjectReflectDiff(in, out))
+		}
+	}
+}
+
+func TestServiceRouteRoundTrip(t *testing.T) {
+	f := newFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		in := &ServiceRoute{}
+		f.Fuzz(in)
+		hub := &v1alpha1.ServiceRoute{}
+		if err := in.ConvertTo(hub); err != nil {
+			t.Fatalf("ConvertTo: %v", err)
+		}
+		out := &ServiceRoute{}
+		if err := out.ConvertFrom(hub); err != nil {
+			t.Fatalf("ConvertFrom: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(in, out) {
+			t.Fatalf("v1beta1 round trip changed the ServiceRoute:\n%s", diff.ObjectReflectDiff(in, out))
+		}
+	}
+
+	f = newFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		in := &v1alpha1.ServiceRoute{}
+		f.Fuzz(in)
+		spoke := &ServiceRoute{}
+		if err := spoke.ConvertFrom(in); err != nil {
+			t.Fatalf("ConvertFrom: %v", err)
+		}
+		out := &v1alpha1.ServiceRoute{}
+		if err := spoke.ConvertTo(out); err != nil {
+			t.Fatalf("ConvertTo: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(in, out) {
+			t.Fatalf("v1alpha1 round trip changed the ServiceRoute:\n%s", diff.ObjectReflectDiff(in, out))
+		}
+	}
+}
+
+func TestOrbitConvertFromLegacyHeaders(t *testing.T) {
+	hub := &v1alpha1.Orbit{Spec: v1alpha1.OrbitSpec{
+		TrafficRules: v1alpha1.TrafficRulesSpec{
+			Headers:   map[string]string{"version": "ignored", "X-Tenant": "ignored"},
+			Propagate: []v1alpha1.HeaderPropagation{{Name: "x-tenant", Env: "TENANT_ID"}},
+		},
+		ApplicationEnv: &v1alpha1.ApplicationEnvSpec{Containers: []string{"app"}},
+	}}
+	spoke := &Orbit{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+
+	want := OrbitSpec{
+		TrafficRules: TrafficRulesSpec{Propagate: []HeaderPropagation{
+			{Name: "version"},
+			{Name: "x-tenant", Env: "TENANT_ID"},
+		}},
+		ApplicationEnv: &ApplicationEnvSpec{Containers: []string{"app"}},
+	}
+	if !apiequality.Semantic.DeepEqual(spoke.Spec, want) {
+		t.Errorf("unexpected spec:\n%s", diff.ObjectReflectDiff(want, spoke.Spec))
+	}
+}
+
+func TestServiceRouteConvertFromDeprecatedFields(t *testing.T) {
+	exact := &v1alpha1.StringMatch{Exact: "dev"}
+	hub := &v1alpha1.ServiceRoute{Spec: v1alpha1.ServiceRouteSpec{
+		Name: "reviews",
+		TrafficRoutes: v1alpha1.TrafficRouteSpec{
+			TrafficSubset: []*v1alpha1.Subset{
+				nil,
+				{Name: "dev", Labels: map[string]string{"version": "dev"}, Headers: map[string]*v1alpha1.StringMatch{"version": exact}},
+			},
+			Default: map[string]string{"version": "base"},
+		},
+	}}
+	spoke := &ServiceRoute{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+
+	want := ServiceRouteSpec{
+		Name: "reviews",
+		TrafficRoutes: TrafficRouteSpec{
+			Routes: []Route{{
+				Name:   "dev",
+				Labels: map[string]string{"version": "dev"},
+				Match:  &HTTPMatchRequest{Headers: map[string]*StringMatch{"version": (*StringMatch)(exact)}},
+			}},
+			Baseline: &BaselineRoute{Name: "base", Labels: map[string]string{"version": "base"}},
+		},
+	}
+	if !apiequality.Semantic.DeepEqual(spoke.Spec, want) {
+		t.Errorf("unexpected spec:\n%s", diff.ObjectReflectDiff(want, spoke.Spec))
+	}
+}
+
+func TestOrbitRoundTripLegacyHeaders(t *testing.T) {
+	in := &v1alpha1.Orbit{Spec: v1alpha1.OrbitSpec{
+		TrafficRules: v1alpha1.TrafficRulesSpec{
+			Headers:   map[string]string{"version": "", "x-tenant": ""},
+			Propagate: []v1alpha1.HeaderPropagation{{Name: "x-tenant", Env: "TENANT_ID"}},
+		},
+	}}
+	spoke := &Orbit{}
+	if err := spoke.ConvertFrom(in.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if _, ok := spoke.Annotations[conversionDataAnnotation]; !ok {
+		t.Fatalf("ConvertFrom did not keep the v1alpha1 spec")
+	}
+
+	out := &v1alpha1.Orbit{}
+	if err := spoke.ConvertTo(out); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !apiequality.Semantic.DeepEqual(in, out) {
+		t.Errorf("round trip changed the Orbit:\n%s", diff.ObjectReflectDiff(in, out))
+	}
+
+	// Once the v1beta1 spec changes, the kept spec is stale.
+	if err := spoke.ConvertFrom(in.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	spoke.Spec.TrafficRules.Propagate = spoke.Spec.TrafficRules.Propagate[1:]
+	out = &v1alpha1.Orbit{}
+	if err := spoke.ConvertTo(out); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	want := v1alpha1.OrbitSpec{TrafficRules: v1alpha1.TrafficRulesSpec{
+		Propagate: []v1alpha1.HeaderPropagation{{Name: "x-tenant", Env: "TENANT_ID"}},
+	}}
+	if !apiequality.Semantic.DeepEqual(out.Spec, want) {
+		t.Errorf("unexpected spec:\n%s", diff.ObjectReflectDiff(want, out.Spec))
+	}
+	if _, ok := out.Annotations[conversionDataAnnotation]; ok {
+		t.Errorf("ConvertTo left the conversion data annotation")
+	}
+}
+
+func TestServiceRouteRoundTripDeprecatedFields(t *testing.T) {
+	in := &v1alpha1.ServiceRoute{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"team": "reviews"}},
+		Spec: v1alpha1.ServiceRouteSpec{
+			Name: "reviews",
+			TrafficRoutes: v1alpha1.TrafficRouteSpec{
+				TrafficSubset: []*v1alpha1.Subset{{
+					Name:    "dev",
+					Labels:  map[string]string{"version": "dev"},
+					Headers: map[string]*v1alpha1.StringMatch{"version": {Exact: "dev"}},
+				}},
+				Default: map[string]string{"version": "base"},
+			},
+		},
+	}
+	spoke := &ServiceRoute{}
+	if err := spoke.ConvertFrom(in.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	out := &v1alpha1.ServiceRoute{}
+	if err := spoke.ConvertTo(out); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !apiequality.Semantic.DeepEqual(in, out) {
+		t.Errorf("round trip changed the ServiceRoute:\n%s", diff.ObjectReflectDiff(in, out))
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1beta1 contains API Schema definitions for the network v1beta1 API group
+//
+// v1alpha1 is the hub of the conversions and stays the storage version, so
+// v1beta1 objects are stored as v1alpha1 and v1alpha1 objects are stored
+// unchanged. v1beta1 has no deprecated fields: a v1alpha1 object using them
+// is served with the fields superseding them, and keeps its v1alpha1 spec
+// in the network.kubeorbit.io/conversion-data annotation, so that writing
+// it back unchanged through v1beta1 loses nothing.
+//
+// Moving the storage version to v1beta1 needs a storage migration: once the
+// CRDs store v1beta1, rewrite every Orbit and ServiceRoute, e.g. with the
+// kube-storage-version-migrator or by updating each object unchanged, then
+// remove v1alpha1 from status.storedVersions of both CRDs. Clusters that
+// ran a release storing v1beta1 need no migration to go back: v1beta1
+// stays served and stored v1beta1 objects are converted on read.
+//+kubebuilder:object:generate=true
+//+groupName=network.kubeorbit.io
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is group version used to register these objects
+	GroupVersion = schema.GroupVersion{Group: "network.kubeorbit.io", Version: "v1beta1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"kubeorbit.io/api/v1alpha1"
+)
+
+var _ conversion.Convertible = &Orbit{}
+
+// ConvertTo converts this Orbit to the hub version, v1alpha1. The v1alpha1
+// spec kept by ConvertFrom is restored unless the spec was changed since.
+func (src *Orbit) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.Orbit)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = orbitSpecToHub(src.Spec)
+	dst.Status = v1alpha1.OrbitStatus(src.Status)
+
+	restored := v1alpha1.OrbitSpec{}
+	ok, err := unmarshalData(&dst.ObjectMeta, &restored)
+	if err != nil {
+		return err
+	}
+	if ok && apiequality.Semantic.DeepEqual(orbitSpecFromHub(restored), src.Spec) {
+		dst.Spec = restored
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version, v1alpha1, to this version. The
+// legacy header map becomes propagation rules reading ORBIT_CHANNEL_TAG,
+// and the v1alpha1 spec is kept in an annotation.
+func (dst *Orbit) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.Orbit)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = orbitSpecFromHub(src.Spec)
+	dst.Status = OrbitStatus(src.Status)
+
+	if _, err := unmarshalData(&dst.ObjectMeta, &v1alpha1.OrbitSpec{}); err != nil {
+		return err
+	}
+	if !apiequality.Semantic.DeepEqual(orbitSpecToHub(dst.Spec), src.Spec) {
+		return marshalData(&dst.ObjectMeta, src.Spec)
+	}
+	return nil
+}
+
+func orbitSpecToHub(spec OrbitSpec) v1alpha1.OrbitSpec {
+	out := v1alpha1.OrbitSpec{
+		MeshProvider:     spec.MeshProvider,
+		WorkloadSelector: (*v1alpha1.WorkloadSelector)(spec.WorkloadSelector),
+		Scope:            v1alpha1.OrbitScope(spec.Scope),
+		ApplicationEnv:   (*v1alpha1.ApplicationEnvSpec)(spec.ApplicationEnv),
+	}
+	for _, h := range spec.TrafficRules.Propagate {
+		out.TrafficRules.Propagate = append(out.TrafficRules.Propagate, v1alpha1.HeaderPropagation(h))
+	}
+	return out
+}
+
+func orbitSpecFromHub(spec v1alpha1.OrbitSpec) OrbitSpec {
+	out := OrbitSpec{
+		MeshProvider:     spec.MeshProvider,
+		WorkloadSelector: (*WorkloadSelector)(spec.WorkloadSelector),
+		Scope:            OrbitScope(spec.Scope),
+		ApplicationEnv:   (*ApplicationEnvSpec)(spec.ApplicationEnv),
+	}
+	for _, h := range spec.TrafficRules.PropagatedHeaders() {
+		out.TrafficRules.Propagate = append(out.TrafficRules.Propagate, HeaderPropagation(h))
+	}
+	return out
+}
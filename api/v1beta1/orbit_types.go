@@ -0,0 +1,148 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TrafficRulesSpec lists the headers propagated by an Orbit.
+type TrafficRulesSpec struct {
+	// Propagate lists the request headers to propagate along with the
+	// source of their values.
+	Propagate []HeaderPropagation `json:"propagate,omitempty"`
+}
+
+// HeaderPropagation describes a request header set on outbound requests
+// when the caller has not set it already.
+type HeaderPropagation struct {
+	// Name of the request header.
+	Name string `json:"name"`
+
+	// Value is a literal value for the header.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Env names the sidecar environment variable holding the header value.
+	// The filter runs in the sidecar and cannot read the environment of the
+	// application containers: the variable must be set on the sidecar, e.g.
+	// through the proxyMetadata of the proxy.istio.io/config pod annotation
+	// or of the mesh config. Defaults to ORBIT_CHANNEL_TAG, which the pod
+	// webhook sets. Ignored when Value is set.
+	// +optional
+	Env string `json:"env,omitempty"`
+}
+
+// WorkloadSelector selects the workloads an Orbit applies to by pod labels.
+type WorkloadSelector struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// OrbitScope tells where the generated configuration is placed.
+// +kubebuilder:validation:Enum=Namespace;Mesh
+type OrbitScope string
+
+const (
+	// OrbitScopeNamespace applies the Orbit to workloads in its own namespace.
+	OrbitScopeNamespace OrbitScope = "Namespace"
+	// OrbitScopeMesh places the generated configuration in the mesh root
+	// namespace so that it applies to workloads in every namespace.
+	OrbitScopeMesh OrbitScope = "Mesh"
+)
+
+// OrbitSpec defines the desired state of Orbit
+type OrbitSpec struct {
+	// MeshProvider selects the data plane the headers are propagated by.
+	// Defaults to the controller's default provider.
+	// +optional
+	MeshProvider string `json:"provider,omitempty"`
+
+	TrafficRules TrafficRulesSpec `json:"trafficRules"`
+
+	// WorkloadSelector limits header propagation to the selected workloads.
+	// Every workload in scope is selected when unset.
+	// +optional
+	WorkloadSelector *WorkloadSelector `json:"workloadSelector,omitempty"`
+
+	// Scope is either Namespace or Mesh. Defaults to Namespace.
+	// +optional
+	// +kubebuilder:default=Namespace
+	Scope OrbitScope `json:"scope,omitempty"`
+
+	// ApplicationEnv exposes the channel of the selected workloads to their
+	// application containers, not only to the sidecar.
+	// +optional
+	ApplicationEnv *ApplicationEnvSpec `json:"applicationEnv,omitempty"`
+}
+
+// ApplicationEnvSpec selects the application containers the pod webhook
+// sets ORBIT_CHANNEL_TAG on. The variable reads the channel label of the
+// pod through the Downward API. Containers defining it already are left
+// alone.
+type ApplicationEnvSpec struct {
+	// Containers lists the names of the containers to set the variable on.
+	// Every container but the sidecar when empty.
+	// +optional
+	Containers []string `json:"containers,omitempty"`
+}
+
+// OrbitStatus defines the observed state of Orbit
+type OrbitStatus struct {
+	// ObservedGeneration is the generation of the spec last processed by
+	// the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the current state of the Orbit.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// EnvoyFilterRef references the EnvoyFilter generated for this Orbit.
+	// +optional
+	EnvoyFilterRef *corev1.ObjectReference `json:"envoyFilterRef,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.provider`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Orbit is the Schema for the orbits API
+type Orbit struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OrbitSpec   `json:"spec,omitempty"`
+	Status OrbitStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OrbitList contains a list of Orbit
+type OrbitList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Orbit `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Orbit{}, &OrbitList{})
+}
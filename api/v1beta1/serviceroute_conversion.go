@@ -0,0 +1,263 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"kubeorbit.io/api/v1alpha1"
+)
+
+var _ conversion.Convertible = &ServiceRoute{}
+
+// ConvertTo converts this ServiceRoute to the hub version, v1alpha1. The
+// v1alpha1 spec kept by ConvertFrom is restored unless the spec was changed
+// since.
+func (src *ServiceRoute) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.ServiceRoute)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = serviceRouteSpecToHub(src.Spec)
+
+	dst.Status = v1alpha1.ServiceRouteStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         src.Status.Conditions,
+		Channels:           src.Status.Channels,
+		ChannelLabel:       src.Status.ChannelLabel,
+	}
+	for _, s := range src.Status.Subsets {
+		dst.Status.Subsets = append(dst.Status.Subsets, v1alpha1.SubsetStatus(s))
+	}
+
+	restored := v1alpha1.ServiceRouteSpec{}
+	ok, err := unmarshalData(&dst.ObjectMeta, &restored)
+	if err != nil {
+		return err
+	}
+	if ok && apiequality.Semantic.DeepEqual(serviceRouteSpecFromHub(restored), src.Spec) {
+		dst.Spec = restored
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version, v1alpha1, to this version.
+// The subset headers move into the match, and the deprecated default map
+// becomes the baseline it stands for. The v1alpha1 spec is then kept in an
+// annotation.
+func (dst *ServiceRoute) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.ServiceRoute)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = serviceRouteSpecFromHub(src.Spec)
+
+	dst.Status = ServiceRouteStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         src.Status.Conditions,
+		Channels:           src.Status.Channels,
+		ChannelLabel:       src.Status.ChannelLabel,
+	}
+	for _, s := range src.Status.Subsets {
+		dst.Status.Subsets = append(dst.Status.Subsets, SubsetStatus(s))
+	}
+
+	if _, err := unmarshalData(&dst.ObjectMeta, &v1alpha1.ServiceRouteSpec{}); err != nil {
+		return err
+	}
+	if !apiequality.Semantic.DeepEqual(serviceRouteSpecToHub(dst.Spec), src.Spec) {
+		return marshalData(&dst.ObjectMeta, src.Spec)
+	}
+	return nil
+}
+
+func serviceRouteSpecToHub(spec ServiceRouteSpec) v1alpha1.ServiceRouteSpec {
+	routes := spec.TrafficRoutes
+	out := v1alpha1.ServiceRouteSpec{
+		TrafficRoutes: v1alpha1.TrafficRouteSpec{
+			// v1alpha1 requires the routes, even when there are none.
+			TrafficSubset: make([]*v1alpha1.Subset, 0, len(routes.Routes)),
+			Baseline:      baselineToHub(routes.Baseline),
+			Discovery:     (*v1alpha1.DiscoverySpec)(routes.Discovery),
+			Mirror:        (*v1alpha1.MirrorRoute)(routes.Mirror),
+		},
+		Name:         spec.Name,
+		Host:         spec.Host,
+		ExportTo:     spec.ExportTo,
+		Merge:        (*v1alpha1.MergeSpec)(spec.Merge),
+		MeshProvider: spec.MeshProvider,
+	}
+	for _, r := range routes.Routes {
+		out.TrafficRoutes.TrafficSubset = append(out.TrafficRoutes.TrafficSubset, &v1alpha1.Subset{
+			Name:        r.Name,
+			Labels:      r.Labels,
+			Match:       matchToHub(r.Match),
+			Weights:     weightsToHub(r.Weights),
+			Fallback:    r.Fallback,
+			RoutePolicy: policyToHub(r.RoutePolicy),
+		})
+	}
+	return out
+}
+
+func serviceRouteSpecFromHub(spec v1alpha1.ServiceRouteSpec) ServiceRouteSpec {
+	routes := spec.TrafficRoutes
+	out := ServiceRouteSpec{
+		TrafficRoutes: TrafficRouteSpec{
+			Baseline:  baselineFromHub(routes.BaselineRoute()),
+			Discovery: (*DiscoverySpec)(routes.Discovery),
+			Mirror:    (*MirrorRoute)(routes.Mirror),
+		},
+		Name:         spec.Name,
+		Host:         spec.Host,
+		ExportTo:     spec.ExportTo,
+		Merge:        (*MergeSpec)(spec.Merge),
+		MeshProvider: spec.MeshProvider,
+	}
+	for _, s := range routes.TrafficSubset {
+		if s == nil {
+			continue
+		}
+		r := Route{
+			Name:        s.Name,
+			Labels:      s.Labels,
+			Weights:     weightsFromHub(s.Weights),
+			Fallback:    s.Fallback,
+			RoutePolicy: policyFromHub(s.RoutePolicy),
+		}
+		if s.Match != nil || len(s.Headers) > 0 {
+			r.Match = matchFromHub(s.HTTPMatch())
+		}
+		out.TrafficRoutes.Routes = append(out.TrafficRoutes.Routes, r)
+	}
+	return out
+}
+
+func baselineToHub(b *BaselineRoute) *v1alpha1.BaselineRoute {
+	if b == nil {
+		return nil
+	}
+	return &v1alpha1.BaselineRoute{
+		Name:        b.Name,
+		Labels:      b.Labels,
+		Weights:     weightsToHub(b.Weights),
+		RoutePolicy: policyToHub(b.RoutePolicy),
+	}
+}
+
+func baselineFromHub(b *v1alpha1.BaselineRoute) *BaselineRoute {
+	if b == nil {
+		return nil
+	}
+	return &BaselineRoute{
+		Name:        b.Name,
+		Labels:      b.Labels,
+		Weights:     weightsFromHub(b.Weights),
+		RoutePolicy: policyFromHub(b.RoutePolicy),
+	}
+}
+
+func weightsToHub(weights []WeightedDestination) []v1alpha1.WeightedDestination {
+	var out []v1alpha1.WeightedDestination
+	for _, w := range weights {
+		out = append(out, v1alpha1.WeightedDestination(w))
+	}
+	return out
+}
+
+func weightsFromHub(weights []v1alpha1.WeightedDestination) []WeightedDestination {
+	var out []WeightedDestination
+	for _, w := range weights {
+		out = append(out, WeightedDestination(w))
+	}
+	return out
+}
+
+func policyToHub(p RoutePolicy) v1alpha1.RoutePolicy {
+	out := v1alpha1.RoutePolicy{
+		Timeout: p.Timeout,
+		Retries: (*v1alpha1.HTTPRetry)(p.Retries),
+	}
+	if p.Fault != nil {
+		out.Fault = &v1alpha1.HTTPFaultInjection{
+			Delay: (*v1alpha1.FaultDelay)(p.Fault.Delay),
+			Abort: (*v1alpha1.FaultAbort)(p.Fault.Abort),
+		}
+	}
+	return out
+}
+
+func policyFromHub(p v1alpha1.RoutePolicy) RoutePolicy {
+	out := RoutePolicy{
+		Timeout: p.Timeout,
+		Retries: (*HTTPRetry)(p.Retries),
+	}
+	if p.Fault != nil {
+		out.Fault = &HTTPFaultInjection{
+			Delay: (*FaultDelay)(p.Fault.Delay),
+			Abort: (*FaultAbort)(p.Fault.Abort),
+		}
+	}
+	return out
+}
+
+func matchToHub(m *HTTPMatchRequest) *v1alpha1.HTTPMatchRequest {
+	if m == nil {
+		return nil
+	}
+	return &v1alpha1.HTTPMatchRequest{
+		URI:            (*v1alpha1.StringMatch)(m.URI),
+		Method:         (*v1alpha1.StringMatch)(m.Method),
+		Authority:      (*v1alpha1.StringMatch)(m.Authority),
+		Headers:        stringMatchesToHub(m.Headers),
+		QueryParams:    stringMatchesToHub(m.QueryParams),
+		WithoutHeaders: stringMatchesToHub(m.WithoutHeaders),
+	}
+}
+
+func matchFromHub(m *v1alpha1.HTTPMatchRequest) *HTTPMatchRequest {
+	if m == nil {
+		return nil
+	}
+	return &HTTPMatchRequest{
+		URI:            (*StringMatch)(m.URI),
+		Method:         (*StringMatch)(m.Method),
+		Authority:      (*StringMatch)(m.Authority),
+		Headers:        stringMatchesFromHub(m.Headers),
+		QueryParams:    stringMatchesFromHub(m.QueryParams),
+		WithoutHeaders: stringMatchesFromHub(m.WithoutHeaders),
+	}
+}
+
+func stringMatchesToHub(matches map[string]*StringMatch) map[string]*v1alpha1.StringMatch {
+	if matches == nil {
+		return nil
+	}
+	out := make(map[string]*v1alpha1.StringMatch, len(matches))
+	for k, v := range matches {
+		out[k] = (*v1alpha1.StringMatch)(v)
+	}
+	return out
+}
+
+func stringMatchesFromHub(matches map[string]*v1alpha1.StringMatch) map[string]*StringMatch {
+	if matches == nil {
+		return nil
+	}
+	out := make(map[string]*StringMatch, len(matches))
+	for k, v := range matches {
+		out[k] = (*StringMatch)(v)
+	}
+	return out
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TrafficRouteSpec lists the routes of a ServiceRoute.
+type TrafficRouteSpec struct {
+	// Routes send the requests meeting their conditions to a subset. The
+	// first matching route wins.
+	// +optional
+	Routes []Route `json:"routes,omitempty"`
+
+	// Baseline takes the traffic matching no route.
+	// +optional
+	Baseline *BaselineRoute `json:"baseline,omitempty"`
+
+	// Discovery generates a subset and a header route for every channel
+	// found on the pods of the routed Service, next to the routes above.
+	// +optional
+	Discovery *DiscoverySpec `json:"discovery,omitempty"`
+
+	// Mirror shadows a share of the traffic matching no route to a subset.
+	// +optional
+	Mirror *MirrorRoute `json:"mirror,omitempty"`
+}
+
+// Route defines a subset and the requests routed to it.
+type Route struct {
+	// Name of the subset.
+	Name string `json:"name"`
+
+	// Labels select the pods of the subset.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Match holds the conditions a request must all meet to take the
+	// route. Subsets without conditions only serve as destinations of
+	// weighted routes.
+	// +optional
+	Match *HTTPMatchRequest `json:"match,omitempty"`
+
+	// Weights splits the traffic matching the route between subsets,
+	// instead of sending it all to this one.
+	// +optional
+	Weights []WeightedDestination `json:"weights,omitempty"`
+
+	// Fallback leaves the route out while none of its destinations has a
+	// ready endpoint, so that the matching requests fall through to the
+	// baseline instead of failing. The route comes back with the endpoints.
+	// +optional
+	Fallback bool `json:"fallback,omitempty"`
+
+	RoutePolicy `json:",inline"`
+}
+
+// BaselineRoute is the terminal route of a ServiceRoute.
+type BaselineRoute struct {
+	// Name of the baseline subset. Without labels, it names one of the
+	// subsets of the routes.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Labels select the pods of the baseline subset.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Weights splits the traffic between subsets, instead of sending it
+	// all to the baseline subset.
+	// +optional
+	Weights []WeightedDestination `json:"weights,omitempty"`
+
+	RoutePolicy `json:",inline"`
+}
+
+// MirrorRoute sends copies of requests to a subset. The responses of the
+// subset are discarded.
+type MirrorRoute struct {
+	// Subset is the name of a subset of the ServiceRoute.
+	Subset string `json:"subset"`
+
+	// Percentage of the requests that are mirrored. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// DiscoverySpec tells how channels are found on pods and selected by
+// requests.
+type DiscoverySpec struct {
+	// Label is the pod label holding the channel. Defaults to the channel
+	// label of the namespace of the routed Service, the one read by the pod
+	// webhook.
+	// +optional
+	Label string `json:"label,omitempty"`
+
+	// Header is the request header selecting the channel. Defaults to the
+	// name of the label, without its prefix.
+	// +optional
+	Header string `json:"header,omitempty"`
+
+	// Fallback is set on the generated subsets.
+	// +optional
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// RoutePolicy tunes how the requests taken by a route are handled. It is
+// rendered by the istio provider; the other providers reject routes
+// setting it.
+type RoutePolicy struct {
+	// Timeout of the requests, retries included. Defaults to the timeout
+	// of the mesh.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Retries of the failed requests. Defaults to the retries of the mesh.
+	// +optional
+	Retries *HTTPRetry `json:"retries,omitempty"`
+
+	// Fault injects delays and aborts into the requests.
+	// +optional
+	Fault *HTTPFaultInjection `json:"fault,omitempty"`
+}
+
+// HTTPRetry describes the retries of a route.
+type HTTPRetry struct {
+	// Attempts is the number of retries. 0 disables retries.
+	// +kubebuilder:validation:Minimum=0
+	Attempts int32 `json:"attempts"`
+
+	// PerTryTimeout is the timeout of each attempt.
+	// +optional
+	PerTryTimeout *metav1.Duration `json:"perTryTimeout,omitempty"`
+
+	// RetryOn lists the conditions to retry on, separated by commas, such
+	// as 5xx,connect-failure.
+	// +optional
+	RetryOn string `json:"retryOn,omitempty"`
+}
+
+// HTTPFaultInjection describes the faults injected into the requests of a
+// route.
+type HTTPFaultInjection struct {
+	// Delay holds the requests before forwarding them.
+	// +optional
+	Delay *FaultDelay `json:"delay,omitempty"`
+
+	// Abort answers the requests with an error status instead of
+	// forwarding them.
+	// +optional
+	Abort *FaultAbort `json:"abort,omitempty"`
+}
+
+// FaultDelay delays a share of the requests.
+type FaultDelay struct {
+	// FixedDelay is how long the requests are held.
+	FixedDelay metav1.Duration `json:"fixedDelay"`
+
+	// Percentage of the requests that are delayed. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// FaultAbort aborts a share of the requests.
+type FaultAbort struct {
+	// HTTPStatus is the status the aborted requests are answered with.
+	// +kubebuilder:validation:Minimum=200
+	// +kubebuilder:validation:Maximum=599
+	HTTPStatus int32 `json:"httpStatus"`
+
+	// Percentage of the requests that are aborted. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// WeightedDestination sends a share of the traffic of a route to a subset.
+type WeightedDestination struct {
+	// Subset is the name of a subset of the ServiceRoute.
+	Subset string `json:"subset"`
+
+	// Weight is the percentage of the traffic sent to the subset. The
+	// weights of a route sum to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+}
+
+// HTTPMatchRequest holds the conditions a request must all meet to take a
+// route.
+type HTTPMatchRequest struct {
+	// URI matches the request path.
+	// +optional
+	URI *StringMatch `json:"uri,omitempty"`
+
+	// Method matches the HTTP method.
+	// +optional
+	Method *StringMatch `json:"method,omitempty"`
+
+	// Authority matches the HTTP authority, the Host header.
+	// +optional
+	Authority *StringMatch `json:"authority,omitempty"`
+
+	// Headers match request headers by name. Header names are case
+	// insensitive.
+	// +optional
+	Headers map[string]*StringMatch `json:"headers,omitempty"`
+
+	// QueryParams match query parameters by name.
+	// +optional
+	QueryParams map[string]*StringMatch `json:"queryParams,omitempty"`
+
+	// WithoutHeaders matches requests whose headers do not match, or which
+	// lack the header.
+	// +optional
+	WithoutHeaders map[string]*StringMatch `json:"withoutHeaders,omitempty"`
+}
+
+// StringMatch matches a string value. Exactly one of the fields is set.
+// +kubebuilder:validation:MinProperties=1
+// +kubebuilder:validation:MaxProperties=1
+type StringMatch struct {
+	// Exact matches the whole value.
+	Exact string `json:"exact,omitempty"`
+
+	// Prefix matches the beginning of the value.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Suffix matches the end of the value.
+	Suffix string `json:"suffix,omitempty"`
+
+	// Regex is an RE2 expression the whole value matches.
+	Regex string `json:"regex,omitempty"`
+}
+
+// ServiceRouteSpec defines the desired state of ServiceRoute
+type ServiceRouteSpec struct {
+	TrafficRoutes TrafficRouteSpec `json:"trafficRoutes"`
+
+	// Name of the routed Service, in the namespace of the ServiceRoute.
+	// One of name or host must be set.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Host is the routed host: the FQDN of a Service of any namespace, such
+	// as reviews.bookinfo.svc.cluster.local, or a ServiceEntry host. Other
+	// namespaces and ServiceEntry hosts are only routed by the istio
+	// provider.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// ExportTo lists the namespaces the generated routes are visible to:
+	// "." for the namespace of the ServiceRoute, "*" for every namespace.
+	// Defaults to the mesh default. Only rendered by the istio provider.
+	// +optional
+	ExportTo []string `json:"exportTo,omitempty"`
+
+	// Merge injects the routes into an existing VirtualService instead of
+	// generating one. Only rendered by the istio provider.
+	// +optional
+	Merge *MergeSpec `json:"merge,omitempty"`
+
+	// MeshProvider selects the data plane the routes are rendered for.
+	// Defaults to the controller's default provider.
+	// +optional
+	MeshProvider string `json:"provider,omitempty"`
+}
+
+// MergeSpec names the VirtualService the routes of a ServiceRoute are
+// merged into.
+type MergeSpec struct {
+	// VirtualService is the name of a VirtualService of the namespace of
+	// the ServiceRoute that routes its host.
+	VirtualService string `json:"virtualService"`
+}
+
+// SubsetStatus describes the endpoints of one subset.
+type SubsetStatus struct {
+	// Name of the subset.
+	Name string `json:"name"`
+
+	// Endpoints is the number of pods of the routed Service that carry the
+	// subset labels.
+	Endpoints int32 `json:"endpoints"`
+
+	// ReadyEndpoints is the number of those pods that are ready.
+	ReadyEndpoints int32 `json:"readyEndpoints"`
+}
+
+// ServiceRouteStatus defines the observed state of ServiceRoute
+type ServiceRouteStatus struct {
+	// ObservedGeneration is the generation of the spec last processed by
+	// the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the current state of the ServiceRoute.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Subsets reports the endpoints of each subset, the baseline included.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Subsets []SubsetStatus `json:"subsets,omitempty"`
+
+	// Channels lists the channels discovered on the pods of the routed
+	// Service, sorted.
+	// +optional
+	Channels []string `json:"channels,omitempty"`
+
+	// ChannelLabel is the pod label the channels were discovered by.
+	// +optional
+	ChannelLabel string `json:"channelLabel,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.name`
+//+kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.host`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServiceRoute is the Schema for the serviceroutes API
+type ServiceRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceRouteSpec   `json:"spec,omitempty"`
+	Status ServiceRouteStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ServiceRouteList contains a list of ServiceRoute
+type ServiceRouteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceRoute `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceRoute{}, &ServiceRouteList{})
+}
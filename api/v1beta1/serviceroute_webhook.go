@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"kubeorbit.io/api/v1alpha1"
+)
+
+// ServiceRouteWebhook defaults and validates v1beta1 ServiceRoutes with the
+// rules of the hub version.
+// +kubebuilder:object:generate=false
+type ServiceRouteWebhook struct {
+	// Providers lists the mesh providers known to the controller, which
+	// spec.provider must name.
+	Providers []string
+	// DefaultProvider is set on ServiceRoutes that select no provider.
+	DefaultProvider string
+}
+
+func (w *ServiceRouteWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&ServiceRoute{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+func (w *ServiceRouteWebhook) hub() *v1alpha1.ServiceRouteWebhook {
+	return &v1alpha1.ServiceRouteWebhook{Providers: w.Providers, DefaultProvider: w.DefaultProvider}
+}
+
+// toHub converts obj, a ServiceRoute of this version, to the hub version.
+func (w *ServiceRouteWebhook) toHub(obj runtime.Object) (*ServiceRoute, *v1alpha1.ServiceRoute, error) {
+	r, ok := obj.(*ServiceRoute)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected a ServiceRoute but got a %T", obj)
+	}
+	hub := &v1alpha1.ServiceRoute{}
+	if err := r.ConvertTo(hub); err != nil {
+		return nil, nil, fmt.Errorf("ServiceRoute %s.%s conversion error: %w", r.Name, r.Namespace, err)
+	}
+	return r, hub, nil
+}
+
+//+kubebuilder:webhook:path=/mutate-network-kubeorbit-io-v1beta1-serviceroute,mutating=true,failurePolicy=fail,sideEffects=None,groups=network.kubeorbit.io,resources=serviceroutes,verbs=create;update,versions=v1beta1,name=mserviceroutev1beta1.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomDefaulter = &ServiceRouteWebhook{}
+
+// Default implements admission.CustomDefaulter.
+func (w *ServiceRouteWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	r, hub, err := w.toHub(obj)
+	if err != nil {
+		return err
+	}
+	if err := w.hub().Default(ctx, hub); err != nil {
+		return err
+	}
+	return r.ConvertFrom(hub)
+}
+
+//+kubebuilder:webhook:path=/validate-network-kubeorbit-io-v1beta1-serviceroute,mutating=false,failurePolicy=fail,sideEffects=None,groups=network.kubeorbit.io,resources=serviceroutes,verbs=create;update,versions=v1beta1,name=vserviceroutev1beta1.kb.io,admissionReviewVersions=v1
+
+var _ admission.CustomValidator = &ServiceRouteWebhook{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (w *ServiceRouteWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	_, hub, err := w.toHub(obj)
+	if err != nil {
+		return err
+	}
+	return w.hub().ValidateCreate(ctx, hub)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (w *ServiceRouteWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	_, hub, err := w.toHub(newObj)
+	if err != nil {
+		return err
+	}
+	_, oldHub, err := w.toHub(oldObj)
+	if err != nil {
+		return err
+	}
+	return w.hub().ValidateUpdate(ctx, oldHub, hub)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (w *ServiceRouteWebhook) ValidateDelete(_ context.Context, _ runtime.Object) error {
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	v1 "kubeorbit.io/api/v1"
+	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
+	"kubeorbit.io/pkg/router"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(orbitv1alpha1.AddToScheme(scheme))
+}
+
+func main() {
+	var namespace string
+	var serviceRoute string
+	var listenPorts string
+	var probeAddr string
+	var channelLabel string
+	var defaultProvider string
+	flag.StringVar(&namespace, "namespace", "", "The namespace of the ServiceRoute to serve.")
+	flag.StringVar(&serviceRoute, "service-route", "", "The name of the ServiceRoute to serve.")
+	flag.StringVar(&listenPorts, "listen-ports", "", "Comma separated list of the ports to route traffic on.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":9091", "The address the probe endpoint binds to.")
+	flag.StringVar(&channelLabel, "channel-label", v1.KUBEORBIT_CHANNEL_LABEL,
+		"The pod label holding the channel of the subset pods.")
+	flag.StringVar(&defaultProvider, "default-provider", "istio",
+		"The mesh provider of the Orbits that do not set spec.provider.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if namespace == "" || serviceRoute == "" {
+		setupLog.Info("--namespace and --service-route are required")
+		os.Exit(1)
+	}
+	var ports []int32
+	for _, p := range strings.Split(listenPorts, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		port, err := strconv.ParseInt(p, 10, 32)
+		if err != nil {
+			setupLog.Error(err, "invalid listen port", "port", p)
+			os.Exit(1)
+		}
+		ports = append(ports, int32(port))
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Namespace:              namespace,
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: probeAddr,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	r := router.New(mgr.GetLogger().WithName("router"), ports)
+	if err = (&router.TableReconciler{
+		Client:       mgr.GetClient(),
+		Log:          mgr.GetLogger(),
+		Router:       r,
+		ServiceRoute: k8stypes.NamespacedName{Namespace: namespace, Name: serviceRoute},
+		Options:      router.TableOptions{ChannelLabel: channelLabel, DefaultProvider: defaultProvider},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceRoute")
+		os.Exit(1)
+	}
+	if err := mgr.Add(r); err != nil {
+		setupLog.Error(err, "unable to add router")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", r.Ready); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting router", "serviceroute", serviceRoute, "namespace", namespace)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running router")
+		os.Exit(1)
+	}
+}
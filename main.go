@@ -17,11 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	v1 "kubeorbit.io/api/v1"
 	controllers "kubeorbit.io/pkg/controllers"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -37,6 +39,7 @@ import (
 
 	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
 	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+	networkv1beta1 "kubeorbit.io/api/v1beta1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -49,6 +52,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(orbitv1alpha1.AddToScheme(scheme))
 	utilruntime.Must(routev1alpha1.AddToScheme(scheme))
+	utilruntime.Must(networkv1beta1.AddToScheme(scheme))
 	utilruntime.Must(istiov1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
@@ -57,11 +61,26 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var istioRootNamespace string
+	var enabledProviders string
+	var defaultProvider string
+	var routerImage string
+	var channelLabel string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&istioRootNamespace, "istio-root-namespace", "istio-system",
+		"The Istio root namespace, where EnvoyFilters of mesh scoped Orbits are created.")
+	flag.StringVar(&enabledProviders, "providers", "istio",
+		"Comma separated list of the mesh providers to enable: istio, linkerd, gateway-api, none.")
+	flag.StringVar(&defaultProvider, "default-provider", "istio",
+		"The mesh provider used by ServiceRoutes that do not set spec.provider.")
+	flag.StringVar(&routerImage, "router-image", "orbit-router:latest",
+		"The orbit-router image deployed in front of the Services routed by the none provider.")
+	flag.StringVar(&channelLabel, "channel-label", v1.KUBEORBIT_CHANNEL_LABEL,
+		"The pod label holding the channel, unless the namespace sets the "+v1.KUBEORBIT_CHANNEL_LABEL_ANNOTATION+" annotation.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -70,6 +89,11 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := v1.ValidateChannelLabel(channelLabel); err != nil {
+		setupLog.Error(err, "invalid --channel-label flag")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -83,24 +107,63 @@ func main() {
 		os.Exit(1)
 	}
 
+	channelLabels := &v1.ChannelLabels{Client: mgr.GetClient(), Default: channelLabel}
+	providers, err := controllers.NewProviderRegistry(strings.Split(enabledProviders, ","), defaultProvider,
+		controllers.ProviderOptions{
+			Client:             mgr.GetClient(),
+			Scheme:             mgr.GetScheme(),
+			Log:                mgr.GetLogger(),
+			IstioRootNamespace: istioRootNamespace,
+			RouterImage:        routerImage,
+			ChannelLabels:      channelLabels,
+		})
+	if err != nil {
+		setupLog.Error(err, "unable to set up mesh providers")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.OrbitReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    mgr.GetLogger(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Log:       mgr.GetLogger(),
+		Providers: providers,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Orbit")
 		os.Exit(1)
 	}
 	if err = (&controllers.ServiceRouteReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    mgr.GetLogger(),
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Log:           mgr.GetLogger(),
+		Providers:     providers,
+		ChannelLabels: channelLabels,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ServiceRoute")
 		os.Exit(1)
 	}
 
-	mgr.GetWebhookServer().Register("/mutate-core-v1-pod", &webhook.Admission{Handler: v1.NewPodSideCarMutate(mgr.GetClient())})
+	knownProviders := controllers.KnownProviders()
+	if err = (&routev1alpha1.OrbitWebhook{Providers: knownProviders, DefaultProvider: defaultProvider}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Orbit")
+		os.Exit(1)
+	}
+	if err = (&routev1alpha1.ServiceRouteWebhook{Providers: knownProviders, DefaultProvider: defaultProvider}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "ServiceRoute")
+		os.Exit(1)
+	}
+	if err = (&networkv1beta1.OrbitWebhook{Providers: knownProviders, DefaultProvider: defaultProvider}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Orbit", "version", "v1beta1")
+		os.Exit(1)
+	}
+	if err = (&networkv1beta1.ServiceRouteWebhook{Providers: knownProviders, DefaultProvider: defaultProvider}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "ServiceRoute", "version", "v1beta1")
+		os.Exit(1)
+	}
+	if err = v1.IndexOrbitScope(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		setupLog.Error(err, "unable to index Orbits", "webhook", "Pod")
+		os.Exit(1)
+	}
+	mgr.GetWebhookServer().Register("/mutate-core-v1-pod", &webhook.Admission{Handler: v1.NewPodSideCarMutate(mgr.GetClient(), channelLabel)})
 
 	//+kubebuilder:scaffold:builder
 
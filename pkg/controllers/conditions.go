@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition reasons shared by the reconcilers.
+const (
+	reasonSynced                 = "Synced"
+	reasonSyncFailed             = "SyncFailed"
+	reasonProviderSupported      = "ProviderSupported"
+	reasonUnsupportedProvider    = "UnsupportedProvider"
+	reasonReady                  = "Ready"
+	reasonNotReady               = "NotReady"
+	reasonEndpointsReady         = "EndpointsReady"
+	reasonNoReadyEndpoints       = "NoReadyEndpoints"
+	reasonServiceNotFound        = "ServiceNotFound"
+	reasonHostConflict           = "HostConflict"
+	reasonNoConflict             = "NoConflict"
+	reasonPropagationUnsupported = "PropagationUnsupported"
+)
+
+func setCondition(conditions *[]metav1.Condition, generation int64, conditionType string,
+	status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// keepTransitionTimes keeps the last transition time of the conditions
+// whose status is unchanged since previous. Reconcilers report conditions
+// from scratch on each pass, so that the conditions not reported by the
+// current pass, such as those of a previously selected provider or of
+// objects just removed, are dropped.
+func keepTransitionTimes(conditions []metav1.Condition, previous []metav1.Condition) {
+	for i := range conditions {
+		if c := meta.FindStatusCondition(previous, conditions[i].Type); c != nil && c.Status == conditions[i].Status {
+			conditions[i].LastTransitionTime = c.LastTransitionTime
+		}
+	}
+}
+
+// setReadyCondition sets readyType to true when every other condition is
+// true, and to false with the first failing condition's message otherwise.
+// Conditions of the abnormal types, such as Conflicted, fail when true
+// instead.
+func setReadyCondition(conditions *[]metav1.Condition, generation int64, readyType string, abnormal ...string) {
+	for _, c := range *conditions {
+		healthy := metav1.ConditionTrue
+		for _, t := range abnormal {
+			if c.Type == t {
+				healthy = metav1.ConditionFalse
+			}
+		}
+		if c.Type == readyType || c.Status == healthy {
+			continue
+		}
+		setCondition(conditions, generation, readyType, metav1.ConditionFalse, reasonNotReady,
+			fmt.Sprintf("%s: %s", c.Type, c.Message))
+		return
+	}
+	setCondition(conditions, generation, readyType, metav1.ConditionTrue, reasonReady, "")
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+const meshProviderGatewayAPI = "gateway-api"
+
+// gatewayAPIHTTPRouteGVK is the version of the gatewayv1beta1 types the
+// HTTPRoutes are rendered from.
+var gatewayAPIHTTPRouteGVK = schema.GroupVersionKind{
+	Group:   gatewayv1beta1.GroupVersion.Group,
+	Version: gatewayv1beta1.GroupVersion.Version,
+	Kind:    "HTTPRoute",
+}
+
+func init() {
+	RegisterProvider(meshProviderGatewayAPI, newGatewayAPIProvider)
+}
+
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+
+// gatewayAPIProvider routes ServiceRoutes with Gateway API HTTPRoutes
+// attached to the routed Service, as implemented by the meshes following the
+// Gateway API mesh profile. Each header match is sent to a Service selecting
+// the subset pods.
+//
+// The Gateway API has no way to rewrite headers on outbound requests, so
+// Orbits are published as a ConfigMap of propagation rules for the
+// applications to apply.
+type gatewayAPIProvider struct {
+	propagation *propagationConfig
+	routes      *httpRouteRenderer
+}
+
+func newGatewayAPIProvider(opts ProviderOptions) MeshProvider {
+	return &gatewayAPIProvider{
+		propagation: &propagationConfig{
+			Client:   opts.Client,
+			scheme:   opts.Scheme,
+			log:      opts.Log,
+			provider: meshProviderGatewayAPI,
+		},
+		routes: &httpRouteRenderer{
+			routeObjects: routeObjects{
+				Client:   opts.Client,
+				scheme:   opts.Scheme,
+				log:      opts.Log,
+				provider: meshProviderGatewayAPI,
+			},
+			gvk:       gatewayAPIHTTPRouteGVK,
+			parentRef: gatewayAPIParentRef,
+			mirror:    true,
+		},
+	}
+}
+
+// gatewayAPIParentRef attaches a route to one port of a Service, which makes
+// the mesh apply it to the traffic sent to that port. Port is part of the
+// experimental channel of the Gateway API CRDs.
+func gatewayAPIParentRef(target *corev1.Service, port corev1.ServicePort) gatewayv1beta1.ParentReference {
+	group := gatewayv1beta1.Group("")
+	kind := gatewayv1beta1.Kind("Service")
+	portNumber := gatewayv1beta1.PortNumber(port.Port)
+	return gatewayv1beta1.ParentReference{
+		Group: &group,
+		Kind:  &kind,
+		Name:  gatewayv1beta1.ObjectName(target.Name),
+		Port:  &portNumber,
+	}
+}
+
+func (p *gatewayAPIProvider) Name() string {
+	return meshProviderGatewayAPI
+}
+
+func (p *gatewayAPIProvider) OrbitTypes() []client.Object {
+	return []client.Object{&corev1.ConfigMap{}}
+}
+
+func (p *gatewayAPIProvider) ReconcileOrbit(ctx context.Context, orbit *routev1alpha1.Orbit, status *routev1alpha1.OrbitStatus) error {
+	return p.propagation.reconcile(ctx, orbit, status)
+}
+
+func (p *gatewayAPIProvider) FinalizeOrbit(ctx context.Context, orbit *routev1alpha1.Orbit) error {
+	return p.propagation.finalize(ctx, orbit)
+}
+
+func (p *gatewayAPIProvider) ServiceRouteTypes() []client.Object {
+	return p.routes.types()
+}
+
+func (p *gatewayAPIProvider) ReconcileServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	return p.routes.reconcile(ctx, route, status)
+}
+
+func (p *gatewayAPIProvider) FinalizeServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute) error {
+	return p.routes.finalize(ctx, route)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+var _ = Describe("ServiceRoute controller with the gateway-api provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+		route     *routev1alpha1.ServiceRoute
+	)
+
+	getHTTPRoute := func() (*unstructured.Unstructured, error) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gatewayAPIHTTPRouteGVK)
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "reviews-9080"}, obj)
+		return obj, err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "gateway-api-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		namespace = ns.Name
+
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "reviews"},
+				Ports:    []corev1.ServicePort{{Name: "http", Port: 9080, TargetPort: intstr.FromInt(8080)}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, svc)).To(Succeed())
+
+		route = &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name:         "reviews",
+				MeshProvider: meshProviderGatewayAPI,
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:   "dev",
+						Labels: map[string]string{"version": "dev"},
+						Match: &routev1alpha1.HTTPMatchRequest{Headers: map[string]*routev1alpha1.StringMatch{
+							"version": {Prefix: "dev"},
+						}},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+					Mirror: &routev1alpha1.MirrorRoute{Subset: "dev"},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+	})
+
+	It("renders an HTTPRoute accepted unchanged by the Gateway API CRD", func() {
+		var obj *unstructured.Unstructured
+		Eventually(func() error {
+			var err error
+			obj, err = getHTTPRoute()
+			return err
+		}, timeout, interval).Should(Succeed())
+
+		By("keeping the port of the Service parent")
+		parentRefs, _, err := unstructured.NestedSlice(obj.Object, "spec", "parentRefs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parentRefs).To(ConsistOf(map[string]interface{}{
+			"group": "",
+			"kind":  "Service",
+			"name":  "reviews",
+			"port":  int64(9080),
+		}))
+
+		rules, _, err := unstructured.NestedSlice(obj.Object, "spec", "rules")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(2))
+
+		By("matching header prefixes with regexes")
+		matches, _, err := unstructured.NestedSlice(rules[0].(map[string]interface{}), "matches")
+		Expect(err).NotTo(HaveOccurred())
+		headers, _, err := unstructured.NestedSlice(matches[0].(map[string]interface{}), "headers")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(headers).To(ConsistOf(map[string]interface{}{
+			"type":  "RegularExpression",
+			"name":  "version",
+			"value": "dev.*",
+		}))
+		Expect(backendWeights(rules[0])).To(Equal(map[string]int64{"reviews-dev": 100}))
+
+		By("mirroring the baseline traffic")
+		filters, _, err := unstructured.NestedSlice(rules[1].(map[string]interface{}), "filters")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filters).To(HaveLen(1))
+		mirror, _, err := unstructured.NestedMap(filters[0].(map[string]interface{}), "requestMirror", "backendRef")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirror).To(HaveKeyWithValue("name", "reviews-dev"))
+
+		By("leaving the stored route alone once rendered")
+		// An annotation triggers a reconcile without changing the spec.
+		Eventually(func() error {
+			latest := &routev1alpha1.ServiceRoute{}
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(route), latest); err != nil {
+				return err
+			}
+			latest.Annotations = map[string]string{"touched": "true"}
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+		resourceVersion := obj.GetResourceVersion()
+		Consistently(func() (string, error) {
+			latest, err := getHTTPRoute()
+			return latest.GetResourceVersion(), err
+		}, 2*time.Second, interval).Should(Equal(resourceVersion))
+	})
+})
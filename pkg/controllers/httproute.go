@@ -0,0 +1,540 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+	"kubeorbit.io/pkg/router"
+)
+
+const (
+	// serviceRouteLabel marks the objects generated for a ServiceRoute.
+	serviceRouteLabel = "network.kubeorbit.io/serviceroute"
+	// providerLabel names the provider that generated an object.
+	providerLabel = "network.kubeorbit.io/provider"
+)
+
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+
+// routeSubset is the part of the routed Service's endpoints that carry the
+// subset labels.
+type routeSubset struct {
+	Name   string
+	Labels map[string]string
+}
+
+// mirrorRoute returns the mirror of the ServiceRoute, nil when it has none
+// or mirrors to a subset without labels, such as a channel not discovered
+// yet.
+func mirrorRoute(tr *routev1alpha1.ServiceRoute) *routev1alpha1.MirrorRoute {
+	mirror := tr.Spec.TrafficRoutes.Mirror
+	if mirror == nil || mirror.MirrorPercentage() == 0 {
+		return nil
+	}
+	for _, s := range routeSubsets(tr) {
+		if s.Name == mirror.Subset {
+			return mirror
+		}
+	}
+	return nil
+}
+
+// localService returns the routed Service for the providers that only
+// route the Services of the namespace of the ServiceRoute.
+func localService(tr *routev1alpha1.ServiceRoute, provider string) (client.ObjectKey, error) {
+	key, ok := tr.GetServiceKey()
+	if !ok || key.Namespace != tr.Namespace {
+		return key, fmt.Errorf("host %s is not a Service of namespace %s, which the %s provider requires",
+			tr.GetHost(), tr.Namespace, provider)
+	}
+	return key, nil
+}
+
+// unsupportedRoutePolicy reports the first route setting a timeout, retries
+// or faults, which only the istio provider renders.
+func unsupportedRoutePolicy(tr *routev1alpha1.ServiceRoute, provider string) error {
+	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+		if c != nil && c.RoutePolicy.IsSet() {
+			return fmt.Errorf("subset %s: timeout, retries and fault are not supported by the %s provider", c.Name, provider)
+		}
+	}
+	if b := tr.Spec.TrafficRoutes.BaselineRoute(); b != nil && b.RoutePolicy.IsSet() {
+		return fmt.Errorf("baseline: timeout, retries and fault are not supported by the %s provider", provider)
+	}
+	return nil
+}
+
+// routeSubsets returns the subsets of a ServiceRoute, including the
+// baseline subset.
+func routeSubsets(tr *routev1alpha1.ServiceRoute) []routeSubset {
+	subsets := make([]routeSubset, 0)
+	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+		if c.Labels != nil {
+			subsets = append(subsets, routeSubset{Name: c.Name, Labels: c.Labels})
+		}
+	}
+
+	if c := tr.Spec.TrafficRoutes.BaselineRoute().Subset(); c != nil {
+		subsets = append(subsets, routeSubset{Name: c.Name, Labels: c.Labels})
+	}
+	return subsets
+}
+
+// routeObjects creates and prunes the objects a provider generates for
+// ServiceRoutes. They are labelled with the provider, so that finalizing
+// one provider leaves the objects of another alone.
+type routeObjects struct {
+	client.Client
+	scheme   *runtime.Scheme
+	log      logr.Logger
+	provider string
+}
+
+func (o *routeObjects) labels(tr *routev1alpha1.ServiceRoute) map[string]string {
+	return map[string]string{
+		serviceRouteLabel: tr.Name,
+		providerLabel:     o.provider,
+	}
+}
+
+// reconcileSubsetServices gives each subset its own Service, selecting the
+// pods of the routed Service that carry the subset labels.
+func (o *routeObjects) reconcileSubsetServices(ctx context.Context, tr *routev1alpha1.ServiceRoute, target *corev1.Service) error {
+	selector, err := router.AppSelector(target)
+	if err != nil {
+		return err
+	}
+	if len(selector) == 0 {
+		return fmt.Errorf("Service %s.%s has no selector to split into subsets", target.Name, target.Namespace)
+	}
+
+	subsets := routeSubsets(tr)
+	services := make(map[string]bool, len(subsets))
+	for _, subset := range subsets {
+		svc := o.subsetService(tr, target, selector, subset)
+		if err := controllerutil.SetControllerReference(tr, svc, o.scheme); err != nil {
+			return err
+		}
+		if err := o.applyService(ctx, tr, svc); err != nil {
+			return err
+		}
+		services[svc.Name] = true
+	}
+	return o.prune(ctx, tr, &corev1.ServiceList{}, services)
+}
+
+func (o *routeObjects) subsetService(tr *routev1alpha1.ServiceRoute, target *corev1.Service,
+	appSelector map[string]string, subset routeSubset) *corev1.Service {
+	selector := make(map[string]string, len(appSelector)+len(subset.Labels))
+	for k, v := range appSelector {
+		selector[k] = v
+	}
+	for k, v := range subset.Labels {
+		selector[k] = v
+	}
+
+	ports := make([]corev1.ServicePort, 0, len(target.Spec.Ports))
+	for _, port := range target.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:        port.Name,
+			Protocol:    port.Protocol,
+			AppProtocol: port.AppProtocol,
+			Port:        port.Port,
+			TargetPort:  port.TargetPort,
+		})
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      router.SubsetServiceName(target.Name, subset.Name),
+			Namespace: tr.Namespace,
+			Labels:    o.labels(tr),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports:    ports,
+		},
+	}
+}
+
+// httpRouteRenderer renders ServiceRoutes into HTTPRoutes shaped like the
+// Gateway API ones. Each subset gets its own Service, selecting the pods of
+// the routed Service that carry the subset labels, and the HTTPRoute rules
+// pick these Services by header.
+type httpRouteRenderer struct {
+	routeObjects
+
+	// gvk is the HTTPRoute kind served by the data plane.
+	gvk schema.GroupVersionKind
+	// parentRef returns the parent of the route serving one port of the
+	// routed Service.
+	parentRef func(target *corev1.Service, port corev1.ServicePort) gatewayv1beta1.ParentReference
+	// mirror tells whether the data plane supports RequestMirror filters.
+	mirror bool
+}
+
+func (h *httpRouteRenderer) types() []client.Object {
+	return []client.Object{&corev1.Service{}, h.newRoute()}
+}
+
+func (h *httpRouteRenderer) newRoute() *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(h.gvk)
+	return route
+}
+
+func (h *httpRouteRenderer) reconcile(ctx context.Context, tr *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	if err := unsupportedRoutePolicy(tr, h.provider); err != nil {
+		return err
+	}
+	key, err := localService(tr, h.provider)
+	if err != nil {
+		return err
+	}
+	target := &corev1.Service{}
+	if err := h.Get(ctx, key, target); err != nil {
+		return fmt.Errorf("Service %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+	if err := h.reconcileSubsetServices(ctx, tr, target); err != nil {
+		return err
+	}
+
+	routes := make(map[string]bool, len(target.Spec.Ports))
+	for _, port := range target.Spec.Ports {
+		route, err := h.buildRoute(tr, status, target, port)
+		if err != nil {
+			return err
+		}
+		if err := h.applyRoute(ctx, tr, route); err != nil {
+			return err
+		}
+		routes[route.GetName()] = true
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(h.gvk.GroupVersion().WithKind(h.gvk.Kind + "List"))
+	return h.prune(ctx, tr, list, routes)
+}
+
+func (h *httpRouteRenderer) finalize(ctx context.Context, tr *routev1alpha1.ServiceRoute) error {
+	if err := h.prune(ctx, tr, &corev1.ServiceList{}, nil); err != nil {
+		return err
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(h.gvk.GroupVersion().WithKind(h.gvk.Kind + "List"))
+	if err := h.prune(ctx, tr, list, nil); err != nil && !meta.IsNoMatchError(err) {
+		return err
+	}
+	return nil
+}
+
+func (h *httpRouteRenderer) buildRoute(tr *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus,
+	target *corev1.Service, port corev1.ServicePort) (*unstructured.Unstructured, error) {
+	rules, err := buildHTTPRouteRules(tr, status, target, port)
+	if err != nil {
+		return nil, err
+	}
+	// The baseline rule comes last.
+	if mirror := mirrorRoute(tr); mirror != nil && len(tr.Spec.TrafficRoutes.DefaultDestinations()) > 0 {
+		if !h.mirror {
+			return nil, fmt.Errorf("mirror is not supported by the %s provider", h.provider)
+		}
+		filter, err := requestMirrorFilter(target, mirror, port)
+		if err != nil {
+			return nil, err
+		}
+		rules[len(rules)-1].Filters = append(rules[len(rules)-1].Filters, filter)
+	}
+	spec := gatewayv1beta1.HTTPRouteSpec{
+		CommonRouteSpec: gatewayv1beta1.CommonRouteSpec{
+			ParentRefs: []gatewayv1beta1.ParentReference{h.parentRef(target, port)},
+		},
+		Rules: rules,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert HTTPRoute spec: %w", err)
+	}
+
+	route := h.newRoute()
+	route.SetName(fmt.Sprintf("%s-%d", tr.Name, port.Port))
+	route.SetNamespace(tr.Namespace)
+	route.SetLabels(h.labels(tr))
+	route.Object["spec"] = content
+	if err := controllerutil.SetControllerReference(tr, route, h.scheme); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// buildHTTPRouteRules renders the rules for one port of the routed Service.
+// Every field the HTTPRoute schema would default is set, so that the
+// generated spec compares equal to the stored one.
+func buildHTTPRouteRules(tr *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus,
+	target *corev1.Service, port corev1.ServicePort) ([]gatewayv1beta1.HTTPRouteRule, error) {
+	rules := make([]gatewayv1beta1.HTTPRouteRule, 0)
+
+	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+		if !c.Routed() || !c.Active(status) {
+			continue
+		}
+		match, err := httpRouteMatch(c.HTTPMatch())
+		if err != nil {
+			return nil, fmt.Errorf("subset %s: %w", c.Name, err)
+		}
+		rules = append(rules, gatewayv1beta1.HTTPRouteRule{
+			Matches:     []gatewayv1beta1.HTTPRouteMatch{match},
+			BackendRefs: subsetBackendRefs(target, c.Destinations(), port),
+		})
+	}
+
+	if destinations := tr.Spec.TrafficRoutes.DefaultDestinations(); len(destinations) > 0 {
+		rules = append(rules, gatewayv1beta1.HTTPRouteRule{
+			Matches:     []gatewayv1beta1.HTTPRouteMatch{{Path: pathPrefixMatch("/")}},
+			BackendRefs: subsetBackendRefs(target, destinations, port),
+		})
+	}
+
+	return rules, nil
+}
+
+// httpRouteMatch renders the conditions of a subset. HTTPRoutes have no
+// authority or negated header matches, and match methods exactly.
+func httpRouteMatch(m *routev1alpha1.HTTPMatchRequest) (gatewayv1beta1.HTTPRouteMatch, error) {
+	match := gatewayv1beta1.HTTPRouteMatch{Path: pathPrefixMatch("/")}
+	switch {
+	case m.Authority != nil:
+		return match, fmt.Errorf("authority matches are not supported by HTTPRoutes")
+	case len(m.WithoutHeaders) > 0:
+		return match, fmt.Errorf("withoutHeaders matches are not supported by HTTPRoutes")
+	case m.Method != nil && m.Method.Exact == "":
+		return match, fmt.Errorf("method matches must be exact for HTTPRoutes")
+	}
+
+	if m.URI != nil {
+		match.Path = httpPathMatch(m.URI)
+	}
+	if m.Method != nil {
+		method := gatewayv1beta1.HTTPMethod(m.Method.Exact)
+		match.Method = &method
+	}
+	for _, k := range sortedMatchNames(m.Headers) {
+		match.Headers = append(match.Headers, httpHeaderMatch(k, m.Headers[k]))
+	}
+	for _, k := range sortedMatchNames(m.QueryParams) {
+		match.QueryParams = append(match.QueryParams, httpQueryParamMatch(k, m.QueryParams[k]))
+	}
+	return match, nil
+}
+
+func sortedMatchNames(matches map[string]*routev1alpha1.StringMatch) []string {
+	names := make([]string, 0, len(matches))
+	for k, m := range matches {
+		if m != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func subsetBackendRefs(target *corev1.Service, destinations []routev1alpha1.WeightedDestination,
+	port corev1.ServicePort) []gatewayv1beta1.HTTPBackendRef {
+	refs := make([]gatewayv1beta1.HTTPBackendRef, 0, len(destinations))
+	for _, d := range destinations {
+		group := gatewayv1beta1.Group("")
+		kind := gatewayv1beta1.Kind("Service")
+		portNumber := gatewayv1beta1.PortNumber(port.Port)
+		weight := d.Weight
+		refs = append(refs, gatewayv1beta1.HTTPBackendRef{
+			BackendRef: gatewayv1beta1.BackendRef{
+				BackendObjectReference: gatewayv1beta1.BackendObjectReference{
+					Group: &group,
+					Kind:  &kind,
+					Name:  gatewayv1beta1.ObjectName(router.SubsetServiceName(target.Name, d.Subset)),
+					Port:  &portNumber,
+				},
+				Weight: &weight,
+			},
+		})
+	}
+	return refs
+}
+
+// requestMirrorFilter renders the mirror of the baseline rule. HTTPRoutes
+// mirror every request.
+func requestMirrorFilter(target *corev1.Service, mirror *routev1alpha1.MirrorRoute,
+	port corev1.ServicePort) (gatewayv1beta1.HTTPRouteFilter, error) {
+	if mirror.MirrorPercentage() != 100 {
+		return gatewayv1beta1.HTTPRouteFilter{}, fmt.Errorf("mirror percentages other than 100 are not supported by HTTPRoutes")
+	}
+	ref := subsetBackendRefs(target, []routev1alpha1.WeightedDestination{{Subset: mirror.Subset}}, port)[0]
+	return gatewayv1beta1.HTTPRouteFilter{
+		Type:          gatewayv1beta1.HTTPRouteFilterRequestMirror,
+		RequestMirror: &gatewayv1beta1.HTTPRequestMirrorFilter{BackendRef: ref.BackendObjectReference},
+	}, nil
+}
+
+func pathPrefixMatch(prefix string) *gatewayv1beta1.HTTPPathMatch {
+	t := gatewayv1beta1.PathMatchPathPrefix
+	return &gatewayv1beta1.HTTPPathMatch{Type: &t, Value: &prefix}
+}
+
+func headerMatchType(t gatewayv1beta1.HeaderMatchType) *gatewayv1beta1.HeaderMatchType {
+	return &t
+}
+
+// httpPathMatch renders a URI match. Prefixes are matched as regexes, since
+// HTTPRoute path prefixes only match whole path segments.
+func httpPathMatch(match *routev1alpha1.StringMatch) *gatewayv1beta1.HTTPPathMatch {
+	t := gatewayv1beta1.PathMatchRegularExpression
+	value := match.AsRegex()
+	if match.Exact != "" {
+		t = gatewayv1beta1.PathMatchExact
+		value = match.Exact
+	}
+	return &gatewayv1beta1.HTTPPathMatch{Type: &t, Value: &value}
+}
+
+func httpQueryParamMatch(name string, match *routev1alpha1.StringMatch) gatewayv1beta1.HTTPQueryParamMatch {
+	t := gatewayv1beta1.QueryParamMatchRegularExpression
+	value := match.AsRegex()
+	if match.Exact != "" {
+		t = gatewayv1beta1.QueryParamMatchExact
+		value = match.Exact
+	}
+	return gatewayv1beta1.HTTPQueryParamMatch{Type: &t, Name: name, Value: value}
+}
+
+// httpHeaderMatch renders a StringMatch. HTTPRoutes only have exact and
+// regex header matches, so prefix and suffix matches become regexes.
+func httpHeaderMatch(name string, match *routev1alpha1.StringMatch) gatewayv1beta1.HTTPHeaderMatch {
+	if match.Exact != "" {
+		return gatewayv1beta1.HTTPHeaderMatch{
+			Type:  headerMatchType(gatewayv1beta1.HeaderMatchExact),
+			Name:  gatewayv1beta1.HTTPHeaderName(name),
+			Value: match.Exact,
+		}
+	}
+	return gatewayv1beta1.HTTPHeaderMatch{
+		Type:  headerMatchType(gatewayv1beta1.HeaderMatchRegularExpression),
+		Name:  gatewayv1beta1.HTTPHeaderName(name),
+		Value: match.AsRegex(),
+	}
+}
+
+func (o *routeObjects) applyService(ctx context.Context, tr *routev1alpha1.ServiceRoute, svc *corev1.Service) error {
+	current := &corev1.Service{}
+	err := o.Get(ctx, client.ObjectKeyFromObject(svc), current)
+	if errors.IsNotFound(err) {
+		if err := o.Create(ctx, svc); err != nil {
+			return fmt.Errorf("Service %s.%s create error: %w", svc.Name, svc.Namespace, err)
+		}
+		o.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+			Info("Service created", svc.Name, svc.Namespace)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Service %s.%s get query error: %w", svc.Name, svc.Namespace, err)
+	}
+
+	if !metav1.IsControlledBy(current, tr) {
+		return fmt.Errorf("Service %s.%s exists and is not managed by this ServiceRoute", svc.Name, svc.Namespace)
+	}
+	if equality.Semantic.DeepEqual(svc.Labels, current.Labels) &&
+		equality.Semantic.DeepEqual(svc.Spec.Selector, current.Spec.Selector) &&
+		equality.Semantic.DeepEqual(svc.Spec.Ports, current.Spec.Ports) {
+		return nil
+	}
+	clone := current.DeepCopy()
+	clone.Labels = svc.Labels
+	clone.Spec.Selector = svc.Spec.Selector
+	clone.Spec.Ports = svc.Spec.Ports
+	if err := o.Update(ctx, clone); err != nil {
+		return fmt.Errorf("Service %s.%s update error: %w", svc.Name, svc.Namespace, err)
+	}
+	o.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+		Info("Service updated", svc.Name, svc.Namespace)
+	return nil
+}
+
+func (h *httpRouteRenderer) applyRoute(ctx context.Context, tr *routev1alpha1.ServiceRoute, route *unstructured.Unstructured) error {
+	kind := h.gvk.Kind
+	current := h.newRoute()
+	err := h.Get(ctx, client.ObjectKeyFromObject(route), current)
+	if errors.IsNotFound(err) {
+		if err := h.Create(ctx, route); err != nil {
+			return fmt.Errorf("%s %s.%s create error: %w", kind, route.GetName(), route.GetNamespace(), err)
+		}
+		h.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+			Info(kind+" created", route.GetName(), route.GetNamespace())
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s %s.%s get query error: %w", kind, route.GetName(), route.GetNamespace(), err)
+	}
+
+	if equality.Semantic.DeepEqual(route.Object["spec"], current.Object["spec"]) {
+		return nil
+	}
+	clone := current.DeepCopy()
+	clone.Object["spec"] = route.Object["spec"]
+	if err := h.Update(ctx, clone); err != nil {
+		return fmt.Errorf("%s %s.%s update error: %w", kind, route.GetName(), route.GetNamespace(), err)
+	}
+	h.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+		Info(kind+" updated", route.GetName(), route.GetNamespace())
+	return nil
+}
+
+// prune deletes the objects generated for the ServiceRoute whose names are
+// not in keep.
+func (o *routeObjects) prune(ctx context.Context, tr *routev1alpha1.ServiceRoute, list client.ObjectList, keep map[string]bool) error {
+	if err := o.List(ctx, list, client.InNamespace(tr.Namespace), client.MatchingLabels(o.labels(tr))); err != nil {
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok || keep[obj.GetName()] || !metav1.IsControlledBy(obj, tr) {
+			continue
+		}
+		if err := o.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("%s.%s delete error: %w", obj.GetName(), obj.GetNamespace(), err)
+		}
+		o.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+			Info("generated object deleted", obj.GetName(), obj.GetNamespace())
+	}
+	return nil
+}
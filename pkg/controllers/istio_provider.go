@@ -0,0 +1,699 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"istio.io/api/networking/v1alpha3"
+	istiov1 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	v1 "kubeorbit.io/api/v1"
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+const meshProviderIstio = "istio"
+
+func init() {
+	RegisterProvider(meshProviderIstio, newIstioProvider)
+}
+
+//+kubebuilder:rbac:groups=networking.istio.io,resources=envoyfilters,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.istio.io,resources=virtualservices,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.istio.io,resources=destinationrules,verbs=get;list;watch;create;update;patch;delete
+
+// istioProvider propagates Orbit headers with a Lua EnvoyFilter and routes
+// ServiceRoutes with a DestinationRule and a VirtualService.
+type istioProvider struct {
+	client.Client
+	scheme        *runtime.Scheme
+	log           logr.Logger
+	rootNamespace string
+}
+
+func newIstioProvider(opts ProviderOptions) MeshProvider {
+	return &istioProvider{
+		Client:        opts.Client,
+		scheme:        opts.Scheme,
+		log:           opts.Log,
+		rootNamespace: opts.IstioRootNamespace,
+	}
+}
+
+func (p *istioProvider) Name() string {
+	return meshProviderIstio
+}
+
+func (p *istioProvider) OrbitTypes() []client.Object {
+	return []client.Object{&istiov1.EnvoyFilter{}}
+}
+
+func (p *istioProvider) ReconcileOrbit(ctx context.Context, orbit *routev1alpha1.Orbit, status *routev1alpha1.OrbitStatus) error {
+	ref, err := p.reconcileEnvoyFilter(ctx, orbit)
+	if err != nil {
+		setCondition(&status.Conditions, orbit.Generation, routev1alpha1.OrbitConditionEnvoyFilterSynced,
+			metav1.ConditionFalse, reasonSyncFailed, err.Error())
+		return fmt.Errorf("reconcileEnvoyFilter failed: %w", err)
+	}
+
+	status.EnvoyFilterRef = ref
+	setCondition(&status.Conditions, orbit.Generation, routev1alpha1.OrbitConditionEnvoyFilterSynced,
+		metav1.ConditionTrue, reasonSynced, "")
+	return nil
+}
+
+func (p *istioProvider) FinalizeOrbit(ctx context.Context, orbit *routev1alpha1.Orbit) error {
+	if err := p.deleteEnvoyFilter(ctx, orbit, p.meshEnvoyFilterKey(orbit)); err != nil {
+		return err
+	}
+	return p.deleteEnvoyFilter(ctx, orbit, k8stypes.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name})
+}
+
+// meshEnvoyFilterKey returns where the EnvoyFilter of a mesh scoped Orbit
+// lives. The Orbit namespace is part of the name to keep it unique in the
+// root namespace.
+func (p *istioProvider) meshEnvoyFilterKey(orbit *routev1alpha1.Orbit) k8stypes.NamespacedName {
+	return k8stypes.NamespacedName{
+		Namespace: p.rootNamespace,
+		Name:      fmt.Sprintf("%s-%s", orbit.Namespace, orbit.Name),
+	}
+}
+
+func (p *istioProvider) reconcileEnvoyFilter(ctx context.Context, orbit *routev1alpha1.Orbit) (*corev1.ObjectReference, error) {
+	key := k8stypes.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}
+	stale := p.meshEnvoyFilterKey(orbit)
+	if orbit.Spec.Scope == routev1alpha1.OrbitScopeMesh {
+		key, stale = stale, key
+	}
+	if err := p.deleteEnvoyFilter(ctx, orbit, stale); err != nil {
+		return nil, err
+	}
+
+	outboundSpec, err := generateOutboudValue(orbit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate outbound proxy: %w", err)
+	}
+
+	envoyFilter := &istiov1.EnvoyFilter{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: istiov1.SchemeGroupVersion.String(),
+			Kind:       "EnvoyFilter",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    orbitLabels(orbit),
+		},
+		Spec: buildHttpFilter(outboundSpec, orbit.Spec.WorkloadSelector),
+	}
+	// Owner references cannot cross namespaces: mesh scoped EnvoyFilters
+	// are deleted by the finalizer.
+	var owner client.Object
+	if key.Namespace == orbit.Namespace {
+		owner = orbit
+	}
+	if err := p.apply(ctx, owner, envoyFilter, "EnvoyFilter",
+		p.log.WithValues("orbit", fmt.Sprintf("%s.%s", orbit.Name, orbit.Namespace))); err != nil {
+		return nil, err
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: istiov1.SchemeGroupVersion.String(),
+		Kind:       "EnvoyFilter",
+		Namespace:  key.Namespace,
+		Name:       key.Name,
+	}, nil
+}
+
+// deleteEnvoyFilter deletes the EnvoyFilter at key if it was generated for
+// the given Orbit.
+func (p *istioProvider) deleteEnvoyFilter(ctx context.Context, orbit *routev1alpha1.Orbit, key k8stypes.NamespacedName) error {
+	envoyFilter := &istiov1.EnvoyFilter{}
+	err := p.Get(ctx, key, envoyFilter)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("EnvoyFilter %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+
+	if !generatedForOrbit(envoyFilter, orbit) {
+		return nil
+	}
+	if err := p.Delete(ctx, envoyFilter); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("EnvoyFilter %s.%s delete error: %w", key.Name, key.Namespace, err)
+	}
+	p.log.WithValues("orbit", fmt.Sprintf("%s.%s", orbit.Name, orbit.Namespace)).
+		Info("EnvoyFilter deleted", key.Name, key.Namespace)
+	return nil
+}
+
+func generateOutboudValue(orbit *routev1alpha1.Orbit) (*types.Struct, error) {
+	var out = &types.Struct{}
+
+	out.Fields = map[string]*types.Value{}
+	out.Fields["@type"] = &types.Value{Kind: &types.Value_StringValue{
+		StringValue: "type.googleapis.com/envoy.extensions.filters.http.lua.v3.Lua",
+	}}
+
+	out.Fields["inlineCode"] = &types.Value{Kind: &types.Value_StringValue{
+		StringValue: buildLuaCode(orbit.Spec.TrafficRules.PropagatedHeaders()),
+	}}
+
+	return &types.Struct{
+		Fields: map[string]*types.Value{
+			"name": {
+				Kind: &types.Value_StringValue{
+					StringValue: "envoy.lua",
+				},
+			},
+			"typed_config": {
+				Kind: &types.Value_StructValue{StructValue: out},
+			},
+		},
+	}, nil
+}
+
+func buildLuaCode(headers []routev1alpha1.HeaderPropagation) string {
+	var b strings.Builder
+	b.WriteString("function envoy_on_request(handle)\n")
+	b.WriteString("  local headers = handle:headers()\n")
+	for _, h := range headers {
+		name := luaQuote(h.Name)
+		fmt.Fprintf(&b, "  if headers:get(%s) == nil then\n", name)
+		if h.Value != "" {
+			fmt.Fprintf(&b, "    headers:add(%s, %s)\n", name, luaQuote(h.Value))
+		} else {
+			env := h.Env
+			if env == "" {
+				env = v1.KUBEORBIT_CHANNEL_ENV
+			}
+			fmt.Fprintf(&b, "    local value = os.getenv(%s)\n", luaQuote(env))
+			b.WriteString("    if value ~= nil then\n")
+			fmt.Fprintf(&b, "      headers:add(%s, value)\n", name)
+			b.WriteString("    end\n")
+		}
+		b.WriteString("  end\n")
+	}
+	b.WriteString("end")
+	return b.String()
+}
+
+// luaQuote returns s as a double-quoted Lua string literal.
+func luaQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func buildHttpFilter(outboundSpec *types.Struct, selector *routev1alpha1.WorkloadSelector) v1alpha3.EnvoyFilter {
+	var workloadSelector *v1alpha3.WorkloadSelector
+	if selector != nil && len(selector.Labels) > 0 {
+		workloadSelector = &v1alpha3.WorkloadSelector{Labels: selector.Labels}
+	}
+
+	return v1alpha3.EnvoyFilter{
+		WorkloadSelector: workloadSelector,
+		ConfigPatches: []*v1alpha3.EnvoyFilter_EnvoyConfigObjectPatch{
+			{
+				ApplyTo: v1alpha3.EnvoyFilter_HTTP_FILTER,
+				Match: &v1alpha3.EnvoyFilter_EnvoyConfigObjectMatch{
+					Context: v1alpha3.EnvoyFilter_SIDECAR_OUTBOUND,
+					ObjectTypes: &v1alpha3.EnvoyFilter_EnvoyConfigObjectMatch_Listener{
+						Listener: &v1alpha3.EnvoyFilter_ListenerMatch{
+							FilterChain: &v1alpha3.EnvoyFilter_ListenerMatch_FilterChainMatch{
+								Filter: &v1alpha3.EnvoyFilter_ListenerMatch_FilterMatch{
+									Name: "envoy.filters.network.http_connection_manager",
+									SubFilter: &v1alpha3.EnvoyFilter_ListenerMatch_SubFilterMatch{
+										Name: "envoy.filters.http.router",
+									},
+								},
+							},
+						}},
+				},
+				Patch: &v1alpha3.EnvoyFilter_Patch{
+					Operation: v1alpha3.EnvoyFilter_Patch_INSERT_BEFORE,
+					Value:     outboundSpec,
+				},
+			},
+		},
+	}
+}
+
+func (p *istioProvider) ServiceRouteTypes() []client.Object {
+	return []client.Object{&istiov1.DestinationRule{}, &istiov1.VirtualService{}}
+}
+
+func (p *istioProvider) ReconcileServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	if err := p.reconcileDestinationRule(ctx, route); err != nil {
+		setCondition(&status.Conditions, route.Generation, routev1alpha1.ServiceRouteConditionDestinationRuleSynced,
+			metav1.ConditionFalse, reasonSyncFailed, err.Error())
+		return fmt.Errorf("reconcileDestinationRule failed: %w", err)
+	}
+	setCondition(&status.Conditions, route.Generation, routev1alpha1.ServiceRouteConditionDestinationRuleSynced,
+		metav1.ConditionTrue, reasonSynced, "")
+
+	if err := p.reconcileVirtualService(ctx, route, status); err != nil {
+		setCondition(&status.Conditions, route.Generation, routev1alpha1.ServiceRouteConditionVirtualServiceSynced,
+			metav1.ConditionFalse, reasonSyncFailed, err.Error())
+		return fmt.Errorf("reconcileVirtualService failed: %w", err)
+	}
+	setCondition(&status.Conditions, route.Generation, routev1alpha1.ServiceRouteConditionVirtualServiceSynced,
+		metav1.ConditionTrue, reasonSynced, "")
+	return nil
+}
+
+func (p *istioProvider) FinalizeServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute) error {
+	// Routes are only merged for ServiceRoutes carrying the finalizer.
+	if controllerutil.ContainsFinalizer(route, serviceRouteFinalizer) {
+		if err := p.unmergeVirtualServices(ctx, route, ""); err != nil {
+			return err
+		}
+	}
+	if err := deleteOwned(ctx, p.Client, route, &istiov1.DestinationRule{}, "DestinationRule"); err != nil {
+		return err
+	}
+	return deleteOwned(ctx, p.Client, route, &istiov1.VirtualService{}, "VirtualService")
+}
+
+// FinalizesServiceRoute is true in merge mode, as the routes injected into
+// a VirtualService the ServiceRoute does not own must be removed.
+func (p *istioProvider) FinalizesServiceRoute(route *routev1alpha1.ServiceRoute) bool {
+	return route.Spec.Merge != nil
+}
+
+func (p *istioProvider) MergeTypes() []client.Object {
+	return []client.Object{&istiov1.VirtualService{}}
+}
+
+func (p *istioProvider) reconcileDestinationRule(ctx context.Context, tr *routev1alpha1.ServiceRoute) error {
+	newSpec := v1alpha3.DestinationRule{
+		Host:     tr.GetHost(),
+		Subsets:  buildRoute(tr),
+		ExportTo: tr.Spec.ExportTo,
+	}
+
+	destinationRule := &istiov1.DestinationRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: istiov1.SchemeGroupVersion.String(),
+			Kind:       "DestinationRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tr.Name,
+			Namespace: tr.Namespace,
+		},
+		Spec: newSpec,
+	}
+	return p.apply(ctx, tr, destinationRule, "DestinationRule",
+		p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)))
+}
+
+func (p *istioProvider) reconcileVirtualService(ctx context.Context, tr *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	if tr.Spec.Merge != nil {
+		if err := deleteOwned(ctx, p.Client, tr, &istiov1.VirtualService{}, "VirtualService"); err != nil {
+			return err
+		}
+		return p.mergeVirtualService(ctx, tr, status)
+	}
+	// The finalizer outlives merge mode until the routes are unmerged.
+	if controllerutil.ContainsFinalizer(tr, serviceRouteFinalizer) {
+		if err := p.unmergeVirtualServices(ctx, tr, ""); err != nil {
+			return err
+		}
+	}
+
+	newSpec := v1alpha3.VirtualService{
+		Hosts: []string{
+			tr.GetHost(),
+		},
+		Http:     buildHTTP(tr, status),
+		ExportTo: tr.Spec.ExportTo,
+	}
+
+	virtualService := &istiov1.VirtualService{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: istiov1.SchemeGroupVersion.String(),
+			Kind:       "VirtualService",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tr.Name,
+			Namespace: tr.Namespace,
+		},
+		Spec: newSpec,
+	}
+	return p.apply(ctx, tr, virtualService, "VirtualService",
+		p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)))
+}
+
+// mergedRoutesPrefix is the name prefix of every route merged into a
+// VirtualService.
+const mergedRoutesPrefix = "kubeorbit/"
+
+// mergedRoutePrefix is the name prefix of the routes merged into
+// VirtualServices for a ServiceRoute. Object names cannot hold a slash, so
+// that the prefix of one ServiceRoute is never the prefix of another.
+func mergedRoutePrefix(tr *routev1alpha1.ServiceRoute) string {
+	return mergedRoutesPrefix + tr.Name + "/"
+}
+
+// mergedRouteOwner returns the name of the ServiceRoute a merged route was
+// merged for.
+func mergedRouteOwner(routeName string) string {
+	owner := strings.TrimPrefix(routeName, mergedRoutesPrefix)
+	if i := strings.Index(owner, "/"); i >= 0 {
+		owner = owner[:i]
+	}
+	return owner
+}
+
+// mergeVirtualService puts the subset routes ahead of the routes of the
+// VirtualService named by spec.merge, replacing the ones merged before.
+// The routes merged for several ServiceRoutes are ordered by ServiceRoute
+// name, so that they do not reorder each other.
+// The VirtualService is updated rather than applied: its http routes are a
+// single field, which another manager owns.
+func (p *istioProvider) mergeVirtualService(ctx context.Context, tr *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	name := tr.Spec.Merge.VirtualService
+	virtualService := &istiov1.VirtualService{}
+	key := client.ObjectKey{Namespace: tr.Namespace, Name: name}
+	if err := p.Get(ctx, key, virtualService); err != nil {
+		return fmt.Errorf("VirtualService %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+	if !containsString(virtualService.Spec.Hosts, tr.GetHost()) {
+		return fmt.Errorf("VirtualService %s.%s does not route host %s", key.Name, key.Namespace, tr.GetHost())
+	}
+
+	prefix := mergedRoutePrefix(tr)
+	var merged, routes []*v1alpha3.HTTPRoute
+	for _, route := range virtualService.Spec.Http {
+		switch {
+		case strings.HasPrefix(route.Name, prefix):
+		case strings.HasPrefix(route.Name, mergedRoutesPrefix):
+			merged = append(merged, route)
+		default:
+			routes = append(routes, route)
+		}
+	}
+	index := 0
+	for _, route := range buildHTTP(tr, status) {
+		// The routes of the VirtualService take the place of the baseline.
+		if len(route.Match) == 0 {
+			continue
+		}
+		route.Name = fmt.Sprintf("%s%d", prefix, index)
+		merged = append(merged, route)
+		index++
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return mergedRouteOwner(merged[i].Name) < mergedRouteOwner(merged[j].Name)
+	})
+	http := append(merged, routes...)
+	if equalHTTPRoutes(http, virtualService.Spec.Http) {
+		return nil
+	}
+
+	// The routes are only merged elsewhere when spec.merge was changed
+	// since they were merged into this VirtualService.
+	if err := p.unmergeVirtualServices(ctx, tr, name); err != nil {
+		return err
+	}
+
+	clone := virtualService.DeepCopy()
+	clone.Spec.Http = http
+	if err := p.Update(ctx, clone); err != nil {
+		return fmt.Errorf("VirtualService %s.%s update error: %w", key.Name, key.Namespace, err)
+	}
+	p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+		Info("VirtualService merged", key.Name, key.Namespace)
+	return nil
+}
+
+// unmergeVirtualServices removes the routes merged for the ServiceRoute
+// from the VirtualServices of its namespace, but the one named keep.
+func (p *istioProvider) unmergeVirtualServices(ctx context.Context, tr *routev1alpha1.ServiceRoute, keep string) error {
+	list := &istiov1.VirtualServiceList{}
+	if err := p.List(ctx, list, client.InNamespace(tr.Namespace)); err != nil {
+		return fmt.Errorf("VirtualService list query error: %w", err)
+	}
+
+	prefix := mergedRoutePrefix(tr)
+	for _, virtualService := range list.Items {
+		if virtualService.Name == keep {
+			continue
+		}
+		http := withoutMergedRoutes(virtualService.Spec.Http, prefix)
+		if len(http) == len(virtualService.Spec.Http) {
+			continue
+		}
+		clone := virtualService.DeepCopy()
+		clone.Spec.Http = http
+		if err := p.Update(ctx, clone); err != nil {
+			return fmt.Errorf("VirtualService %s.%s update error: %w", clone.Name, clone.Namespace, err)
+		}
+		p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
+			Info("VirtualService unmerged", clone.Name, clone.Namespace)
+	}
+	return nil
+}
+
+func withoutMergedRoutes(routes []*v1alpha3.HTTPRoute, prefix string) []*v1alpha3.HTTPRoute {
+	kept := make([]*v1alpha3.HTTPRoute, 0, len(routes))
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Name, prefix) {
+			kept = append(kept, route)
+		}
+	}
+	return kept
+}
+
+func equalHTTPRoutes(a, b []*v1alpha3.HTTPRoute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// apply server-side applies a generated object, controlled by owner when
+// owner is not nil.
+func (p *istioProvider) apply(ctx context.Context, owner, obj client.Object, kind string, log logr.Logger) error {
+	if owner != nil {
+		if err := controllerutil.SetControllerReference(owner, obj, p.scheme); err != nil {
+			return err
+		}
+	}
+	result, err := serverSideApply(ctx, p.Client, obj, kind)
+	if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info(fmt.Sprintf("%s %s", kind, result), obj.GetName(), obj.GetNamespace())
+	}
+	return nil
+}
+
+func buildRoute(tr *routev1alpha1.ServiceRoute) []*v1alpha3.Subset {
+	subsets := make([]*v1alpha3.Subset, 0)
+
+	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+		if c.Labels != nil {
+			subsets = append(subsets, &v1alpha3.Subset{
+				Name:   c.Name,
+				Labels: c.Labels,
+			})
+		}
+	}
+
+	if c := tr.Spec.TrafficRoutes.BaselineRoute().Subset(); c != nil {
+		subsets = append(subsets, &v1alpha3.Subset{
+			Name:   c.Name,
+			Labels: c.Labels,
+		})
+	}
+
+	return subsets
+}
+
+// istioStringMatch renders the match type set in a StringMatch.
+func istioStringMatch(match *routev1alpha1.StringMatch) *v1alpha3.StringMatch {
+	switch {
+	case match.Prefix != "":
+		return &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Prefix{Prefix: match.Prefix}}
+	case match.Suffix != "":
+		// Istio has no suffix match type.
+		return &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Regex{Regex: match.AsRegex()}}
+	case match.Regex != "":
+		return &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Regex{Regex: match.Regex}}
+	default:
+		return &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Exact{Exact: match.Exact}}
+	}
+}
+
+// istioHTTPMatch renders the conditions of a subset. Istio matches query
+// parameters by exact value or regex only.
+func istioHTTPMatch(match *routev1alpha1.HTTPMatchRequest) *v1alpha3.HTTPMatchRequest {
+	stringMatches := func(matches map[string]*routev1alpha1.StringMatch, regexOnly bool) map[string]*v1alpha3.StringMatch {
+		if len(matches) == 0 {
+			return nil
+		}
+		rendered := make(map[string]*v1alpha3.StringMatch, len(matches))
+		for k, m := range matches {
+			switch {
+			case m == nil:
+			case regexOnly && m.Exact == "":
+				rendered[k] = &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Regex{Regex: m.AsRegex()}}
+			default:
+				rendered[k] = istioStringMatch(m)
+			}
+		}
+		return rendered
+	}
+	stringMatch := func(m *routev1alpha1.StringMatch) *v1alpha3.StringMatch {
+		if m == nil {
+			return nil
+		}
+		return istioStringMatch(m)
+	}
+
+	return &v1alpha3.HTTPMatchRequest{
+		Uri:            stringMatch(match.URI),
+		Method:         stringMatch(match.Method),
+		Authority:      stringMatch(match.Authority),
+		Headers:        stringMatches(match.Headers, false),
+		QueryParams:    stringMatches(match.QueryParams, true),
+		WithoutHeaders: stringMatches(match.WithoutHeaders, false),
+	}
+}
+
+func buildHTTP(tr *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) []*v1alpha3.HTTPRoute {
+	httpRoutes := make([]*v1alpha3.HTTPRoute, 0)
+
+	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+		if c.Routed() && c.Active(status) {
+			route := &v1alpha3.HTTPRoute{
+				Match: []*v1alpha3.HTTPMatchRequest{istioHTTPMatch(c.HTTPMatch())},
+				Route: buildDestinations(tr, c.Destinations()),
+			}
+			istioRoutePolicy(route, &c.RoutePolicy)
+			httpRoutes = append(httpRoutes, route)
+		}
+	}
+
+	if destinations := tr.Spec.TrafficRoutes.DefaultDestinations(); len(destinations) > 0 {
+		route := &v1alpha3.HTTPRoute{
+			Route: buildDestinations(tr, destinations),
+		}
+		istioRoutePolicy(route, &tr.Spec.TrafficRoutes.BaselineRoute().RoutePolicy)
+		if mirror := mirrorRoute(tr); mirror != nil {
+			route.Mirror = &v1alpha3.Destination{
+				Host:   tr.GetHost(),
+				Subset: mirror.Subset,
+			}
+			route.MirrorPercentage = &v1alpha3.Percent{Value: float64(mirror.MirrorPercentage())}
+		}
+		httpRoutes = append(httpRoutes, route)
+	}
+
+	return httpRoutes
+}
+
+// istioRoutePolicy renders the timeout, retries and faults of a route.
+func istioRoutePolicy(route *v1alpha3.HTTPRoute, policy *routev1alpha1.RoutePolicy) {
+	if policy.Timeout != nil {
+		route.Timeout = types.DurationProto(policy.Timeout.Duration)
+	}
+	if r := policy.Retries; r != nil {
+		route.Retries = &v1alpha3.HTTPRetry{Attempts: r.Attempts, RetryOn: r.RetryOn}
+		if r.PerTryTimeout != nil {
+			route.Retries.PerTryTimeout = types.DurationProto(r.PerTryTimeout.Duration)
+		}
+	}
+	if f := policy.Fault; f != nil {
+		route.Fault = &v1alpha3.HTTPFaultInjection{}
+		if f.Delay != nil {
+			route.Fault.Delay = &v1alpha3.HTTPFaultInjection_Delay{
+				HttpDelayType: &v1alpha3.HTTPFaultInjection_Delay_FixedDelay{
+					FixedDelay: types.DurationProto(f.Delay.FixedDelay.Duration),
+				},
+				Percentage: &v1alpha3.Percent{Value: float64(f.Delay.DelayPercentage())},
+			}
+		}
+		if f.Abort != nil {
+			route.Fault.Abort = &v1alpha3.HTTPFaultInjection_Abort{
+				ErrorType:  &v1alpha3.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: f.Abort.HTTPStatus},
+				Percentage: &v1alpha3.Percent{Value: float64(f.Abort.AbortPercentage())},
+			}
+		}
+	}
+}
+
+// buildDestinations renders weighted destinations. A lone destination is
+// left unweighted, as Istio sends it all the traffic anyway.
+func buildDestinations(tr *routev1alpha1.ServiceRoute, destinations []routev1alpha1.WeightedDestination) []*v1alpha3.HTTPRouteDestination {
+	routes := make([]*v1alpha3.HTTPRouteDestination, 0, len(destinations))
+	for _, d := range destinations {
+		route := &v1alpha3.HTTPRouteDestination{
+			Destination: &v1alpha3.Destination{
+				Host:   tr.GetHost(),
+				Subset: d.Subset,
+			},
+		}
+		if len(destinations) > 1 {
+			route.Weight = d.Weight
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
@@ -0,0 +1,722 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"istio.io/api/networking/v1alpha3"
+	istiov1 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+func TestLuaQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "x-tenant", want: `"x-tenant"`},
+		{name: "empty", in: "", want: `""`},
+		{name: "quote", in: `a"b`, want: `"a\"b"`},
+		{name: "backslash", in: `a\b`, want: `"a\\b"`},
+		{name: "newline", in: "a\nb", want: `"a\010b"`},
+		{name: "script injection", in: "\")\nos.exit()--", want: `"\")\010os.exit()--"`},
+		{name: "delete", in: "a\x7f", want: `"a\127"`},
+		{name: "utf-8 bytes", in: "é", want: `"\195\169"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := luaQuote(tt.in); got != tt.want {
+				t.Errorf("luaQuote(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildLuaCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []routev1alpha1.HeaderPropagation
+		want    string
+	}{
+		{
+			name: "no header",
+			want: "function envoy_on_request(handle)\n" +
+				"  local headers = handle:headers()\n" +
+				"end",
+		},
+		{
+			name:    "channel from the default variable",
+			headers: []routev1alpha1.HeaderPropagation{{Name: "version"}},
+			want: "function envoy_on_request(handle)\n" +
+				"  local headers = handle:headers()\n" +
+				"  if headers:get(\"version\") == nil then\n" +
+				"    local value = os.getenv(\"ORBIT_CHANNEL_TAG\")\n" +
+				"    if value ~= nil then\n" +
+				"      headers:add(\"version\", value)\n" +
+				"    end\n" +
+				"  end\n" +
+				"end",
+		},
+		{
+			name: "literal value and other variable",
+			headers: []routev1alpha1.HeaderPropagation{
+				{Name: "x-caller", Value: "tc-apps", Env: "IGNORED"},
+				{Name: "x-tenant", Env: "TENANT_ID"},
+			},
+			want: "function envoy_on_request(handle)\n" +
+				"  local headers = handle:headers()\n" +
+				"  if headers:get(\"x-caller\") == nil then\n" +
+				"    headers:add(\"x-caller\", \"tc-apps\")\n" +
+				"  end\n" +
+				"  if headers:get(\"x-tenant\") == nil then\n" +
+				"    local value = os.getenv(\"TENANT_ID\")\n" +
+				"    if value ~= nil then\n" +
+				"      headers:add(\"x-tenant\", value)\n" +
+				"    end\n" +
+				"  end\n" +
+				"end",
+		},
+		{
+			name:    "quoted value",
+			headers: []routev1alpha1.HeaderPropagation{{Name: "x-note", Value: `say "hi"`}},
+			want: "function envoy_on_request(handle)\n" +
+				"  local headers = handle:headers()\n" +
+				"  if headers:get(\"x-note\") == nil then\n" +
+				"    headers:add(\"x-note\", \"say \\\"hi\\\"\")\n" +
+				"  end\n" +
+				"end",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildLuaCode(tt.headers); got != tt.want {
+				t.Errorf("buildLuaCode() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+var _ = Describe("ServiceRoute fallback with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = newTestNamespace(ctx, "fallback-")
+		newReviewsService(ctx, namespace)
+		newReviewsPod(ctx, namespace, "reviews-dev", map[string]string{"version": "dev"}, false)
+		newReviewsPod(ctx, namespace, "reviews-base", map[string]string{"version": "base"}, true)
+
+		route := &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name: "reviews",
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:     "dev",
+						Labels:   map[string]string{"version": "dev"},
+						Headers:  map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+						Fallback: true,
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+	})
+
+	// routedSubsets returns the subset of the first destination of each
+	// route of the VirtualService.
+	routedSubsets := func() ([]string, error) {
+		virtualService, err := getVirtualService(ctx, namespace, "reviews")
+		if err != nil {
+			return nil, err
+		}
+		subsets := make([]string, 0, len(virtualService.Spec.Http))
+		for _, route := range virtualService.Spec.Http {
+			subsets = append(subsets, route.Route[0].Destination.Subset)
+		}
+		return subsets, nil
+	}
+
+	It("leaves the route out while its subset has no ready endpoint", func() {
+		Eventually(routedSubsets, timeout, interval).Should(Equal([]string{"base"}))
+
+		By("restoring the route with the endpoints")
+		setEndpointReady(ctx, namespace, "reviews-dev", true)
+		Eventually(routedSubsets, timeout, interval).Should(Equal([]string{"dev", "base"}))
+
+		By("dropping it again once they are gone")
+		setEndpointReady(ctx, namespace, "reviews-dev", false)
+		Eventually(routedSubsets, timeout, interval).Should(Equal([]string{"base"}))
+	})
+
+	It("keeps the subset in the DestinationRule", func() {
+		Eventually(func() ([]string, error) {
+			destinationRule := &istiov1.DestinationRule{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "reviews"}, destinationRule); err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(destinationRule.Spec.Subsets))
+			for _, subset := range destinationRule.Spec.Subsets {
+				names = append(names, subset.Name)
+			}
+			return names, nil
+		}, timeout, interval).Should(Equal([]string{"dev", "base"}))
+	})
+})
+
+// getVirtualService returns a stored VirtualService.
+func getVirtualService(ctx context.Context, namespace, name string) (*istiov1.VirtualService, error) {
+	virtualService := &istiov1.VirtualService{}
+	err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, virtualService)
+	return virtualService, err
+}
+
+var _ = Describe("ServiceRoute mirror with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	It("mirrors the baseline traffic to the subset", func() {
+		ctx := context.Background()
+		namespace := newTestNamespace(ctx, "mirror-")
+		newReviewsService(ctx, namespace)
+
+		percentage := int32(25)
+		route := &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name: "reviews",
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:   "dev",
+						Labels: map[string]string{"version": "dev"},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+					Mirror: &routev1alpha1.MirrorRoute{Subset: "dev", Percentage: &percentage},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+
+		var virtualService *istiov1.VirtualService
+		Eventually(func() error {
+			var err error
+			virtualService, err = getVirtualService(ctx, namespace, "reviews")
+			return err
+		}, timeout, interval).Should(Succeed())
+
+		// The subset has no conditions: it only takes mirrored traffic.
+		Expect(virtualService.Spec.Http).To(HaveLen(1))
+		baseline := virtualService.Spec.Http[0]
+		Expect(baseline.Route[0].Destination.Subset).To(Equal("base"))
+		Expect(baseline.Mirror).NotTo(BeNil())
+		Expect(baseline.Mirror.Host).To(Equal("reviews"))
+		Expect(baseline.Mirror.Subset).To(Equal("dev"))
+		Expect(baseline.MirrorPercentage).NotTo(BeNil())
+		Expect(baseline.MirrorPercentage.Value).To(Equal(float64(25)))
+
+		destinationRule := &istiov1.DestinationRule{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "reviews"}, destinationRule)).To(Succeed())
+		Expect(destinationRule.Spec.Subsets).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("ServiceRoute policies with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	It("renders the timeout, retries and faults of each route", func() {
+		ctx := context.Background()
+		namespace := newTestNamespace(ctx, "policy-")
+		newReviewsService(ctx, namespace)
+
+		abortPercentage := int32(10)
+		route := &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name: "reviews",
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:    "dev",
+						Labels:  map[string]string{"version": "dev"},
+						Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+						RoutePolicy: routev1alpha1.RoutePolicy{
+							Retries: &routev1alpha1.HTTPRetry{
+								Attempts:      3,
+								PerTryTimeout: &metav1.Duration{Duration: 500 * time.Millisecond},
+								RetryOn:       "5xx,connect-failure",
+							},
+							Fault: &routev1alpha1.HTTPFaultInjection{
+								Delay: &routev1alpha1.FaultDelay{FixedDelay: metav1.Duration{Duration: time.Second}},
+								Abort: &routev1alpha1.FaultAbort{HTTPStatus: 503, Percentage: &abortPercentage},
+							},
+						},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:        "base",
+						Labels:      map[string]string{"version": "base"},
+						RoutePolicy: routev1alpha1.RoutePolicy{Timeout: &metav1.Duration{Duration: 5 * time.Second}},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+
+		var virtualService *istiov1.VirtualService
+		Eventually(func() error {
+			var err error
+			virtualService, err = getVirtualService(ctx, namespace, "reviews")
+			return err
+		}, timeout, interval).Should(Succeed())
+		Expect(virtualService.Spec.Http).To(HaveLen(2))
+
+		By("rendering the policy of the subset route")
+		dev := virtualService.Spec.Http[0]
+		Expect(dev.Timeout).To(BeNil())
+		Expect(dev.Retries).NotTo(BeNil())
+		Expect(dev.Retries.Attempts).To(Equal(int32(3)))
+		Expect(dev.Retries.RetryOn).To(Equal("5xx,connect-failure"))
+		Expect(dev.Retries.PerTryTimeout.Nanos).To(Equal(int32(500 * time.Millisecond)))
+		Expect(dev.Fault).NotTo(BeNil())
+		Expect(dev.Fault.Delay.GetFixedDelay().Seconds).To(Equal(int64(1)))
+		Expect(dev.Fault.Delay.Percentage.Value).To(Equal(float64(100)))
+		Expect(dev.Fault.Abort.GetHttpStatus()).To(Equal(int32(503)))
+		Expect(dev.Fault.Abort.Percentage.Value).To(Equal(float64(10)))
+
+		By("rendering the policy of the baseline")
+		baseline := virtualService.Spec.Http[1]
+		Expect(baseline.Timeout).NotTo(BeNil())
+		Expect(baseline.Timeout.Seconds).To(Equal(int64(5)))
+		Expect(baseline.Retries).To(BeNil())
+		Expect(baseline.Fault).To(BeNil())
+	})
+})
+
+var _ = Describe("ServiceRoute hosts with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var ctx context.Context
+
+	newRoute := func(namespace, host string) *routev1alpha1.ServiceRoute {
+		return &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Host:     host,
+				ExportTo: []string{"."},
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:    "dev",
+						Labels:  map[string]string{"version": "dev"},
+						Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+				},
+			},
+		}
+	}
+
+	// expectRouted checks that the objects generated for route send host
+	// to the subsets, visible to the namespace of route only.
+	expectRouted := func(route *routev1alpha1.ServiceRoute, host string) {
+		var virtualService *istiov1.VirtualService
+		Eventually(func() error {
+			var err error
+			virtualService, err = getVirtualService(ctx, route.Namespace, route.Name)
+			return err
+		}, timeout, interval).Should(Succeed())
+		Expect(virtualService.Spec.Hosts).To(Equal([]string{host}))
+		Expect(virtualService.Spec.ExportTo).To(Equal([]string{"."}))
+		for _, r := range virtualService.Spec.Http {
+			Expect(r.Route[0].Destination.Host).To(Equal(host))
+		}
+
+		destinationRule := &istiov1.DestinationRule{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(route), destinationRule)).To(Succeed())
+		Expect(destinationRule.Spec.Host).To(Equal(host))
+		Expect(destinationRule.Spec.ExportTo).To(Equal([]string{"."}))
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("routes the FQDN of a Service of another namespace", func() {
+		serviceNamespace := newTestNamespace(ctx, "hosts-service-")
+		newReviewsService(ctx, serviceNamespace)
+		newReviewsPod(ctx, serviceNamespace, "reviews-dev", map[string]string{"version": "dev"}, true)
+
+		host := "reviews." + serviceNamespace + ".svc.cluster.local"
+		route := newRoute(newTestNamespace(ctx, "hosts-"), host)
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+		expectRouted(route, host)
+
+		By("counting the endpoints in the namespace of the Service")
+		Eventually(func() ([]routev1alpha1.SubsetStatus, error) {
+			latest, err := getServiceRoute(ctx, route)
+			return latest.Status.Subsets, err
+		}, timeout, interval).Should(ConsistOf(
+			routev1alpha1.SubsetStatus{Name: "dev", Endpoints: 1, ReadyEndpoints: 1},
+			routev1alpha1.SubsetStatus{Name: "base", Endpoints: 0, ReadyEndpoints: 0},
+		))
+	})
+
+	It("routes a ServiceEntry host", func() {
+		route := newRoute(newTestNamespace(ctx, "hosts-"), "payments.example.com")
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+		expectRouted(route, "payments.example.com")
+
+		By("reporting no subsets for a host without a Service")
+		Eventually(func() (bool, error) {
+			latest, err := getServiceRoute(ctx, route)
+			return meta.IsStatusConditionTrue(latest.Status.Conditions, routev1alpha1.ServiceRouteConditionReady), err
+		}, timeout, interval).Should(BeTrue())
+		latest, err := getServiceRoute(ctx, route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.Subsets).To(BeEmpty())
+		Expect(meta.FindStatusCondition(latest.Status.Conditions, routev1alpha1.ServiceRouteConditionSubsetsAvailable)).To(BeNil())
+	})
+})
+
+var _ = Describe("ServiceRoute field ownership with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+		route     *routev1alpha1.ServiceRoute
+	)
+
+	getDestinationRule := func() (*istiov1.DestinationRule, error) {
+		destinationRule := &istiov1.DestinationRule{}
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "reviews"}, destinationRule)
+		return destinationRule, err
+	}
+
+	managers := func(obj client.Object) map[string]metav1.ManagedFieldsOperationType {
+		managers := map[string]metav1.ManagedFieldsOperationType{}
+		for _, entry := range obj.GetManagedFields() {
+			managers[entry.Manager] = entry.Operation
+		}
+		return managers
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = newTestNamespace(ctx, "ownership-")
+		newReviewsService(ctx, namespace)
+
+		route = &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name: "reviews",
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:    "dev",
+						Labels:  map[string]string{"version": "dev"},
+						Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+				},
+			},
+		}
+	})
+
+	It("keeps the fields other managers set", func() {
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+		Eventually(getDestinationRule, timeout, interval).Should(
+			WithTransform(managers, HaveKeyWithValue(string(fieldOwner), metav1.ManagedFieldsOperationApply)))
+
+		patch := []byte(`{"spec":{"trafficPolicy":{"connectionPool":{"tcp":{"maxConnections":10}}}}}`)
+		Expect(k8sClient.Patch(ctx, &istiov1.DestinationRule{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "reviews"}},
+			client.RawPatch(types.MergePatchType, patch), client.FieldOwner("platform-team"))).To(Succeed())
+
+		By("reconciling a spec change")
+		Eventually(func() error {
+			latest, err := getServiceRoute(ctx, route)
+			if err != nil {
+				return err
+			}
+			latest.Spec.TrafficRoutes.TrafficSubset[0].Name = "canary"
+			latest.Spec.TrafficRoutes.TrafficSubset[0].Labels = map[string]string{"version": "canary"}
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() (string, error) {
+			destinationRule, err := getDestinationRule()
+			if err != nil {
+				return "", err
+			}
+			return destinationRule.Spec.Subsets[0].Name, nil
+		}, timeout, interval).Should(Equal("canary"))
+		destinationRule, err := getDestinationRule()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(destinationRule.Spec.TrafficPolicy).NotTo(BeNil())
+		Expect(destinationRule.Spec.TrafficPolicy.ConnectionPool.Tcp.MaxConnections).To(Equal(int32(10)))
+	})
+
+	It("takes over the fields written before objects were applied", func() {
+		// Releases that did not apply objects created them without a field
+		// manager, as the test client does.
+		legacy := &istiov1.DestinationRule{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "reviews"},
+		}
+		legacy.Spec.Host = "reviews"
+		legacy.Spec.Subsets = []*v1alpha3.Subset{{Name: "dev", Labels: map[string]string{"version": "dev"}}}
+		legacy.Spec.TrafficPolicy = &v1alpha3.TrafficPolicy{
+			LoadBalancer: &v1alpha3.LoadBalancerSettings{LbPolicy: &v1alpha3.LoadBalancerSettings_Simple{
+				Simple: v1alpha3.LoadBalancerSettings_ROUND_ROBIN,
+			}},
+		}
+		Expect(k8sClient.Create(ctx, legacy)).To(Succeed())
+		Expect(managers(legacy)).To(HaveKeyWithValue(legacyFieldManager, metav1.ManagedFieldsOperationUpdate))
+
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+
+		By("dropping the fields the controller no longer sets")
+		Eventually(func() (*v1alpha3.TrafficPolicy, error) {
+			destinationRule, err := getDestinationRule()
+			return destinationRule.Spec.TrafficPolicy, err
+		}, timeout, interval).Should(BeNil())
+
+		destinationRule, err := getDestinationRule()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(destinationRule.Spec.Subsets).To(HaveLen(2))
+		Expect(managers(destinationRule)).To(Equal(map[string]metav1.ManagedFieldsOperationType{
+			string(fieldOwner): metav1.ManagedFieldsOperationApply,
+		}))
+	})
+})
+
+var _ = Describe("ServiceRoute merge with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	newVirtualService := func(name string, hosts ...string) {
+		virtualService := &istiov1.VirtualService{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		}
+		virtualService.Spec.Hosts = hosts
+		virtualService.Spec.Http = []*v1alpha3.HTTPRoute{{
+			Name:  "default",
+			Route: []*v1alpha3.HTTPRouteDestination{{Destination: &v1alpha3.Destination{Host: hosts[0]}}},
+		}}
+		Expect(k8sClient.Create(ctx, virtualService)).To(Succeed())
+	}
+
+	newMergingRoute := func(name, host, virtualService string) *routev1alpha1.ServiceRoute {
+		route := &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Host:  host,
+				Merge: &routev1alpha1.MergeSpec{VirtualService: virtualService},
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:    "dev",
+						Labels:  map[string]string{"version": "dev"},
+						Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+		return route
+	}
+
+	routeNames := func(name string) func() ([]string, error) {
+		return func() ([]string, error) {
+			virtualService, err := getVirtualService(ctx, namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			var names []string
+			for _, route := range virtualService.Spec.Http {
+				names = append(names, route.Name)
+			}
+			return names, nil
+		}
+	}
+
+	updateServiceRoute := func(route *routev1alpha1.ServiceRoute, mutate func(*routev1alpha1.ServiceRoute)) {
+		Eventually(func() error {
+			latest, err := getServiceRoute(ctx, route)
+			if err != nil {
+				return err
+			}
+			mutate(latest)
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	hasFinalizer := func(route *routev1alpha1.ServiceRoute) func() (bool, error) {
+		return func() (bool, error) {
+			latest, err := getServiceRoute(ctx, route)
+			if err != nil {
+				return false, err
+			}
+			return controllerutil.ContainsFinalizer(latest, serviceRouteFinalizer), nil
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = newTestNamespace(ctx, "merge-")
+		newReviewsService(ctx, namespace)
+	})
+
+	It("orders the routes merged for several ServiceRoutes", func() {
+		newVirtualService("frontdoor", "reviews", "ratings")
+		reviews := newMergingRoute("reviews", "reviews", "frontdoor")
+		ratings := newMergingRoute("ratings", "ratings", "frontdoor")
+
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(
+			Equal([]string{"kubeorbit/ratings/0", "kubeorbit/reviews/0", "default"}))
+
+		By("leaving the VirtualService alone on later reconciles")
+		virtualService, err := getVirtualService(ctx, namespace, "frontdoor")
+		Expect(err).NotTo(HaveOccurred())
+		for _, route := range []*routev1alpha1.ServiceRoute{reviews, ratings} {
+			updateServiceRoute(route, func(r *routev1alpha1.ServiceRoute) {
+				r.Annotations = map[string]string{"touched": "true"}
+			})
+		}
+		Consistently(func() (string, error) {
+			latest, err := getVirtualService(ctx, namespace, "frontdoor")
+			return latest.ResourceVersion, err
+		}, 2*time.Second, interval).Should(Equal(virtualService.ResourceVersion))
+	})
+
+	It("removes the merged routes when they are merged elsewhere, no longer merged or deleted", func() {
+		newVirtualService("frontdoor", "reviews")
+		newVirtualService("backdoor", "reviews")
+		route := newMergingRoute("reviews", "reviews", "frontdoor")
+
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(Equal([]string{"kubeorbit/reviews/0", "default"}))
+		Eventually(hasFinalizer(route), timeout, interval).Should(BeTrue())
+
+		By("merging into another VirtualService")
+		updateServiceRoute(route, func(r *routev1alpha1.ServiceRoute) {
+			r.Spec.Merge.VirtualService = "backdoor"
+		})
+		Eventually(routeNames("backdoor"), timeout, interval).Should(Equal([]string{"kubeorbit/reviews/0", "default"}))
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(Equal([]string{"default"}))
+
+		By("generating a VirtualService instead")
+		updateServiceRoute(route, func(r *routev1alpha1.ServiceRoute) {
+			r.Spec.Merge = nil
+		})
+		Eventually(routeNames("reviews"), timeout, interval).ShouldNot(BeEmpty())
+		Eventually(routeNames("backdoor"), timeout, interval).Should(Equal([]string{"default"}))
+		Eventually(hasFinalizer(route), timeout, interval).Should(BeFalse())
+
+		By("deleting the ServiceRoute")
+		updateServiceRoute(route, func(r *routev1alpha1.ServiceRoute) {
+			r.Spec.Merge = &routev1alpha1.MergeSpec{VirtualService: "frontdoor"}
+		})
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(Equal([]string{"kubeorbit/reviews/0", "default"}))
+		Expect(k8sClient.Delete(ctx, route)).To(Succeed())
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(Equal([]string{"default"}))
+		Eventually(func() bool {
+			_, err := getServiceRoute(ctx, route)
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("merges the routes of one ServiceRoute per host", func() {
+		newVirtualService("frontdoor", "reviews")
+		older := newMergingRoute("reviews", "reviews", "frontdoor")
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(Equal([]string{"kubeorbit/reviews/0", "default"}))
+
+		newer := newMergingRoute("reviews-canary", "reviews", "frontdoor")
+		conflicted := func() (metav1.ConditionStatus, error) {
+			latest, err := getServiceRoute(ctx, newer)
+			if err != nil {
+				return "", err
+			}
+			condition := meta.FindStatusCondition(latest.Status.Conditions, routev1alpha1.ServiceRouteConditionConflicted)
+			if condition == nil {
+				return "", nil
+			}
+			return condition.Status, nil
+		}
+		Eventually(conflicted, timeout, interval).Should(Equal(metav1.ConditionTrue))
+		Consistently(routeNames("frontdoor"), 2*time.Second, interval).Should(Equal([]string{"kubeorbit/reviews/0", "default"}))
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: newer.Name}, &istiov1.DestinationRule{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		By("handing the host over once the older ServiceRoute is deleted")
+		Expect(k8sClient.Delete(ctx, older)).To(Succeed())
+		Eventually(conflicted, timeout, interval).Should(Equal(metav1.ConditionFalse))
+		Eventually(routeNames("frontdoor"), timeout, interval).Should(Equal([]string{"kubeorbit/reviews-canary/0", "default"}))
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: newer.Name}, &istiov1.DestinationRule{})
+		}, timeout, interval).Should(Succeed())
+	})
+})
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+const meshProviderLinkerd = "linkerd"
+
+var linkerdHTTPRouteGVK = schema.GroupVersionKind{
+	Group:   "policy.linkerd.io",
+	Version: "v1beta2",
+	Kind:    "HTTPRoute",
+}
+
+func init() {
+	RegisterProvider(meshProviderLinkerd, newLinkerdProvider)
+}
+
+//+kubebuilder:rbac:groups=policy.linkerd.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+
+// linkerdProvider routes ServiceRoutes with Linkerd's dynamic request
+// routing: an HTTPRoute attached to the routed Service sends each header
+// match to a Service selecting the subset pods.
+//
+// Linkerd proxies do not rewrite request headers, so Orbits are published
+// as a ConfigMap of propagation rules for the applications to apply.
+type linkerdProvider struct {
+	propagation *propagationConfig
+	routes      *httpRouteRenderer
+}
+
+func newLinkerdProvider(opts ProviderOptions) MeshProvider {
+	return &linkerdProvider{
+		propagation: &propagationConfig{
+			Client:   opts.Client,
+			scheme:   opts.Scheme,
+			log:      opts.Log,
+			provider: meshProviderLinkerd,
+		},
+		routes: &httpRouteRenderer{
+			routeObjects: routeObjects{
+				Client:   opts.Client,
+				scheme:   opts.Scheme,
+				log:      opts.Log,
+				provider: meshProviderLinkerd,
+			},
+			gvk:       linkerdHTTPRouteGVK,
+			parentRef: linkerdParentRef,
+		},
+	}
+}
+
+// linkerdParentRef attaches a route to one port of a Service, which makes
+// Linkerd apply it on the client side of every meshed caller.
+func linkerdParentRef(target *corev1.Service, port corev1.ServicePort) gatewayv1beta1.ParentReference {
+	group := gatewayv1beta1.Group("core")
+	kind := gatewayv1beta1.Kind("Service")
+	portNumber := gatewayv1beta1.PortNumber(port.Port)
+	return gatewayv1beta1.ParentReference{
+		Group: &group,
+		Kind:  &kind,
+		Name:  gatewayv1beta1.ObjectName(target.Name),
+		Port:  &portNumber,
+	}
+}
+
+func (p *linkerdProvider) Name() string {
+	return meshProviderLinkerd
+}
+
+func (p *linkerdProvider) OrbitTypes() []client.Object {
+	return []client.Object{&corev1.ConfigMap{}}
+}
+
+func (p *linkerdProvider) ReconcileOrbit(ctx context.Context, orbit *routev1alpha1.Orbit, status *routev1alpha1.OrbitStatus) error {
+	return p.propagation.reconcile(ctx, orbit, status)
+}
+
+func (p *linkerdProvider) FinalizeOrbit(ctx context.Context, orbit *routev1alpha1.Orbit) error {
+	return p.propagation.finalize(ctx, orbit)
+}
+
+func (p *linkerdProvider) ServiceRouteTypes() []client.Object {
+	return p.routes.types()
+}
+
+func (p *linkerdProvider) ReconcileServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	return p.routes.reconcile(ctx, route, status)
+}
+
+func (p *linkerdProvider) FinalizeServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute) error {
+	return p.routes.finalize(ctx, route)
+}
@@ -19,33 +19,42 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"github.com/google/go-cmp/cmp"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"log"
 
 	"github.com/go-logr/logr"
-	"github.com/gogo/protobuf/types"
-	"istio.io/api/networking/v1alpha3"
-	istiov1 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+const (
+	// orbitFinalizer guards the cleanup of objects placed outside the Orbit
+	// namespace, which owner references cannot cover.
+	orbitFinalizer      = "network.kubeorbit.io/envoyfilter"
+	orbitNameLabel      = "network.kubeorbit.io/orbit-name"
+	orbitNamespaceLabel = "network.kubeorbit.io/orbit-namespace"
 )
 
 // OrbitReconciler reconciles a Orbit object
 type OrbitReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Providers *ProviderRegistry
 }
 
 //+kubebuilder:rbac:groups=network.kubeorbit.io,resources=orbits,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=network.kubeorbit.io,resources=orbits/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=network.kubeorbit.io,resources=orbits/finalizers,verbs=update
-//+kubebuilder:rbac:groups=networking.istio.io,resources=envoyfilters,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -57,140 +66,128 @@ type OrbitReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
 func (r *OrbitReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = r.Log.WithValues("orbit", req.NamespacedName)
+	logger := r.Log.WithValues("orbit", req.NamespacedName)
 	obj := &orbitv1alpha1.Orbit{}
 
 	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
-		log.Println(err, "unable to fetch object")
-	} else {
-		if err := r.reconcileEnvoyFilter(obj, req); err != nil {
-			return ctrl.Result{}, fmt.Errorf("reconcileEnvoyFilter failed: %w", err)
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
 		}
+		logger.Error(err, "unable to fetch object")
+		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
-}
+	if !obj.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(obj, orbitFinalizer) {
+			for _, p := range r.Providers.Providers() {
+				if err := p.FinalizeOrbit(ctx, obj); err != nil {
+					return ctrl.Result{}, fmt.Errorf("%s FinalizeOrbit failed: %w", p.Name(), err)
+				}
+			}
+			controllerutil.RemoveFinalizer(obj, orbitFinalizer)
+			if err := r.Update(ctx, obj); err != nil {
+				return ctrl.Result{}, fmt.Errorf("Orbit %s.%s finalizer removal error: %w", obj.Name, obj.Namespace, err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
 
-func (r *OrbitReconciler) reconcileEnvoyFilter(orbit *orbitv1alpha1.Orbit, req ctrl.Request) error {
-	envoyName := orbit.Name
-	outboundSpec, err := generateOutboudValue(orbit)
-	if err != nil {
-		return fmt.Errorf("failed to generate outbound proxy: %w", err)
+	if (obj.Spec.Scope == orbitv1alpha1.OrbitScopeMesh) != controllerutil.ContainsFinalizer(obj, orbitFinalizer) {
+		if obj.Spec.Scope == orbitv1alpha1.OrbitScopeMesh {
+			controllerutil.AddFinalizer(obj, orbitFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(obj, orbitFinalizer)
+		}
+		if err := r.Update(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("Orbit %s.%s finalizer update error: %w", obj.Name, obj.Namespace, err)
+		}
 	}
 
-	newSpec := buildHttpFilter(outboundSpec)
-	envoyFilter := &istiov1.EnvoyFilter{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      envoyName,
-			Namespace: orbit.Namespace,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(orbit, schema.GroupVersionKind{
-					Group:   orbit.GroupVersionKind().Group,
-					Version: orbit.GroupVersionKind().Version,
-					Kind:    orbit.Kind,
-				}),
-			},
-		},
-		Spec: newSpec,
+	status := obj.Status.DeepCopy()
+	previous := status.Conditions
+	status.Conditions = nil
+	generation := obj.Generation
+	var reconcileErr error
+
+	provider, err := r.Providers.Get(obj.Spec.MeshProvider)
+	if err != nil {
+		setCondition(&status.Conditions, generation, orbitv1alpha1.OrbitConditionProviderSupported,
+			metav1.ConditionFalse, reasonUnsupportedProvider, err.Error())
+	} else {
+		setCondition(&status.Conditions, generation, orbitv1alpha1.OrbitConditionProviderSupported,
+			metav1.ConditionTrue, reasonProviderSupported, "")
 	}
 
-	err = r.Get(context.TODO(), req.NamespacedName, envoyFilter)
-	if errors.IsNotFound(err) {
-		err = r.Create(context.TODO(), envoyFilter)
-		if err != nil {
-			return fmt.Errorf("EnvoyFilter %s.%s create error: %w", envoyName, orbit.Namespace, err)
+	// Every provider but the selected one is finalized, so that switching
+	// to another provider, or to one that is disabled or unsupported,
+	// removes what the previous one generated.
+	for _, p := range r.Providers.Providers() {
+		if p == provider {
+			continue
+		}
+		if err := p.FinalizeOrbit(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("%s FinalizeOrbit failed: %w", p.Name(), err)
 		}
-		r.Log.WithValues("orbit", fmt.Sprintf("%s.%s", orbit.Name, orbit.Namespace)).
-			Info("EnvoyFilter created", envoyFilter.GetName(), orbit.Namespace)
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("EnvoyFilter %s.%s get query error: %w", envoyName, orbit.Namespace, err)
 	}
 
-	if envoyFilter != nil {
-		if diff := cmp.Diff(newSpec, envoyFilter.Spec); diff != "" {
-			clone := envoyFilter.DeepCopy()
-			clone.Spec = newSpec
-			err = r.Update(context.TODO(), clone)
-			if err != nil {
-				return fmt.Errorf("EnvoyFilter %s.%s update error: %w", envoyName, orbit.Namespace, err)
-			}
-			r.Log.WithValues("orbit", fmt.Sprintf("%s.%s", orbit.Name, orbit.Namespace)).
-				Info("EnvoyFilter updated", envoyFilter.GetName(), orbit.Namespace)
+	if provider != nil {
+		if err := provider.ReconcileOrbit(ctx, obj, status); err != nil {
+			reconcileErr = fmt.Errorf("%s ReconcileOrbit failed: %w", provider.Name(), err)
 		}
 	}
 
-	return nil
-}
+	setReadyCondition(&status.Conditions, generation, orbitv1alpha1.OrbitConditionReady)
+	if reconcileErr != nil {
+		setCondition(&status.Conditions, generation, orbitv1alpha1.OrbitConditionReady,
+			metav1.ConditionFalse, reasonSyncFailed, reconcileErr.Error())
+	}
+	keepTransitionTimes(status.Conditions, previous)
+	status.ObservedGeneration = generation
 
-func generateOutboudValue(orbit *orbitv1alpha1.Orbit) (*types.Struct, error) {
-	var out = &types.Struct{}
+	if !equality.Semantic.DeepEqual(status, &obj.Status) {
+		obj.Status = *status
+		if err := r.Status().Update(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("Orbit %s.%s status update error: %w", obj.Name, obj.Namespace, err)
+		}
+	}
 
-	out.Fields = map[string]*types.Value{}
-	out.Fields["@type"] = &types.Value{Kind: &types.Value_StringValue{
-		StringValue: "type.googleapis.com/envoy.extensions.filters.http.lua.v3.Lua",
-	}}
+	return ctrl.Result{}, reconcileErr
+}
 
-	headerKey := ""
-	for k, _ := range orbit.Spec.TrafficRules.Headers {
-		headerKey = k
+// orbitLabels marks objects generated for an Orbit, so they can be found
+// even where owner references do not apply.
+func orbitLabels(orbit *orbitv1alpha1.Orbit) map[string]string {
+	return map[string]string{
+		orbitNameLabel:      orbit.Name,
+		orbitNamespaceLabel: orbit.Namespace,
 	}
-	out.Fields["inlineCode"] = &types.Value{Kind: &types.Value_StringValue{
-		StringValue: fmt.Sprintf(`function envoy_on_request(handle)
-  local tag = handle:headers():get("` + headerKey + `")
-  local channelValue = os.getenv("ORBIT_CHANNEL_TAG")
-  if tag == nil and channelValue ~= nil then
-     handle:headers():add("` + headerKey + `", channelValue)
-  end
-end`),
-	}}
-
-	return &types.Struct{
-		Fields: map[string]*types.Value{
-			"name": {
-				Kind: &types.Value_StringValue{
-					StringValue: "envoy.lua",
-				},
-			},
-			"typed_config": {
-				Kind: &types.Value_StructValue{StructValue: out},
-			},
-		},
-	}, nil
 }
 
-func buildHttpFilter(outboundSpec *types.Struct) v1alpha3.EnvoyFilter {
-	return v1alpha3.EnvoyFilter{
-		ConfigPatches: []*v1alpha3.EnvoyFilter_EnvoyConfigObjectPatch{
-			{
-				ApplyTo: v1alpha3.EnvoyFilter_HTTP_FILTER,
-				Match: &v1alpha3.EnvoyFilter_EnvoyConfigObjectMatch{
-					Context: v1alpha3.EnvoyFilter_SIDECAR_OUTBOUND,
-					ObjectTypes: &v1alpha3.EnvoyFilter_EnvoyConfigObjectMatch_Listener{
-						Listener: &v1alpha3.EnvoyFilter_ListenerMatch{
-							FilterChain: &v1alpha3.EnvoyFilter_ListenerMatch_FilterChainMatch{
-								Filter: &v1alpha3.EnvoyFilter_ListenerMatch_FilterMatch{
-									Name: "envoy.filters.network.http_connection_manager",
-									SubFilter: &v1alpha3.EnvoyFilter_ListenerMatch_SubFilterMatch{
-										Name: "envoy.filters.http.router",
-									},
-								},
-							},
-						}},
-				},
-				Patch: &v1alpha3.EnvoyFilter_Patch{
-					Operation: v1alpha3.EnvoyFilter_Patch_INSERT_BEFORE,
-					Value:     outboundSpec,
-				},
-			},
-		},
-	}
+func generatedForOrbit(obj client.Object, orbit *orbitv1alpha1.Orbit) bool {
+	return obj.GetLabels()[orbitNameLabel] == orbit.Name && obj.GetLabels()[orbitNamespaceLabel] == orbit.Namespace
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *OrbitReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&orbitv1alpha1.Orbit{}).
-		Owns(&istiov1.EnvoyFilter{}).
-		Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&orbitv1alpha1.Orbit{})
+	for _, p := range r.Providers.Providers() {
+		for _, t := range p.OrbitTypes() {
+			b = b.Owns(t).
+				Watches(&source.Kind{Type: t}, handler.EnqueueRequestsFromMapFunc(generatedToOrbit))
+		}
+	}
+	return b.Complete(r)
+}
+
+// generatedToOrbit maps an object placed outside of its Orbit namespace
+// back to the Orbit.
+func generatedToOrbit(obj client.Object) []reconcile.Request {
+	name, namespace := obj.GetLabels()[orbitNameLabel], obj.GetLabels()[orbitNamespaceLabel]
+	if name == "" || namespace == "" || namespace == obj.GetNamespace() {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: k8stypes.NamespacedName{Namespace: namespace, Name: name}},
+	}
 }
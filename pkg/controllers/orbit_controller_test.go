@@ -0,0 +1,287 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	istiov1 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+var _ = Describe("Orbit controller with the linkerd provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx   context.Context
+		orbit *orbitv1alpha1.Orbit
+	)
+
+	getCondition := func(conditionType string) *metav1.Condition {
+		latest := &orbitv1alpha1.Orbit{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, latest); err != nil {
+			return nil
+		}
+		return meta.FindStatusCondition(latest.Status.Conditions, conditionType)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "orbit-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		orbit = &orbitv1alpha1.Orbit{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: ns.Name},
+			Spec: orbitv1alpha1.OrbitSpec{
+				MeshProvider: meshProviderLinkerd,
+				TrafficRules: orbitv1alpha1.TrafficRulesSpec{
+					Headers:   map[string]string{"x-version": ""},
+					Propagate: []orbitv1alpha1.HeaderPropagation{{Name: "x-caller", Value: "frontend"}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, orbit)).To(Succeed())
+	})
+
+	It("publishes the propagation rules for the applications", func() {
+		configMap := &corev1.ConfigMap{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, configMap)
+		}, timeout, interval).Should(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue(propagationConfigKey,
+			`[{"name":"x-version"},{"name":"x-caller","value":"frontend"}]`))
+	})
+
+	It("reports header propagation as unsupported by the data plane", func() {
+		Eventually(func() *metav1.Condition {
+			return getCondition(orbitv1alpha1.OrbitConditionPropagationSynced)
+		}, timeout, interval).ShouldNot(BeNil())
+
+		synced := getCondition(orbitv1alpha1.OrbitConditionPropagationSynced)
+		Expect(synced.Status).To(Equal(metav1.ConditionFalse))
+		Expect(synced.Reason).To(Equal(reasonPropagationUnsupported))
+		Expect(synced.Message).To(ContainSubstring("ConfigMap tenant key headers.json"))
+
+		ready := getCondition(orbitv1alpha1.OrbitConditionReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Message).To(HavePrefix(orbitv1alpha1.OrbitConditionPropagationSynced))
+	})
+})
+
+var _ = Describe("Orbit controller with the istio provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx   context.Context
+		orbit *orbitv1alpha1.Orbit
+	)
+
+	getOrbit := func() (*orbitv1alpha1.Orbit, error) {
+		latest := &orbitv1alpha1.Orbit{}
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, latest)
+		return latest, err
+	}
+
+	updateOrbit := func(mutate func(*orbitv1alpha1.Orbit)) {
+		Eventually(func() error {
+			latest, err := getOrbit()
+			if err != nil {
+				return err
+			}
+			mutate(latest)
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "orbit-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		orbit = &orbitv1alpha1.Orbit{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: ns.Name},
+			Spec: orbitv1alpha1.OrbitSpec{
+				MeshProvider: meshProviderIstio,
+				TrafficRules: orbitv1alpha1.TrafficRulesSpec{
+					Propagate: []orbitv1alpha1.HeaderPropagation{{Name: "x-caller", Value: "frontend"}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, orbit)).To(Succeed())
+	})
+
+	It("reports the generated EnvoyFilter in the status", func() {
+		Eventually(func() (metav1.ConditionStatus, error) {
+			latest, err := getOrbit()
+			if err != nil {
+				return "", err
+			}
+			if c := meta.FindStatusCondition(latest.Status.Conditions, orbitv1alpha1.OrbitConditionReady); c != nil {
+				return c.Status, nil
+			}
+			return "", nil
+		}, timeout, interval).Should(Equal(metav1.ConditionTrue))
+
+		latest, err := getOrbit()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.ObservedGeneration).To(Equal(latest.Generation))
+		Expect(meta.IsStatusConditionTrue(latest.Status.Conditions, orbitv1alpha1.OrbitConditionProviderSupported)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(latest.Status.Conditions, orbitv1alpha1.OrbitConditionEnvoyFilterSynced)).To(BeTrue())
+		Expect(meta.FindStatusCondition(latest.Status.Conditions, orbitv1alpha1.OrbitConditionPropagationSynced)).To(BeNil())
+
+		Expect(latest.Status.EnvoyFilterRef).NotTo(BeNil())
+		Expect(latest.Status.EnvoyFilterRef.Kind).To(Equal("EnvoyFilter"))
+		Expect(latest.Status.EnvoyFilterRef.Namespace).To(Equal(orbit.Namespace))
+		Expect(latest.Status.EnvoyFilterRef.Name).To(Equal(orbit.Name))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name},
+			&istiov1.EnvoyFilter{})).To(Succeed())
+	})
+
+	It("reports a provider the controller does not enable", func() {
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, &istiov1.EnvoyFilter{})
+		}, timeout, interval).Should(Succeed())
+
+		// The none provider is not enabled in the suite.
+		updateOrbit(func(o *orbitv1alpha1.Orbit) { o.Spec.MeshProvider = "none" })
+
+		Eventually(func() (*metav1.Condition, error) {
+			latest, err := getOrbit()
+			if err != nil || latest.Status.ObservedGeneration != latest.Generation {
+				return nil, err
+			}
+			return meta.FindStatusCondition(latest.Status.Conditions, orbitv1alpha1.OrbitConditionProviderSupported), nil
+		}, timeout, interval).Should(And(Not(BeNil()), WithTransform(func(c *metav1.Condition) string {
+			return c.Reason
+		}, Equal(reasonUnsupportedProvider))))
+
+		latest, err := getOrbit()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionFalse(latest.Status.Conditions, orbitv1alpha1.OrbitConditionReady)).To(BeTrue())
+		By("dropping the conditions of the previous provider")
+		Expect(meta.FindStatusCondition(latest.Status.Conditions, orbitv1alpha1.OrbitConditionEnvoyFilterSynced)).To(BeNil())
+
+		By("removing the EnvoyFilter of the previous provider")
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, &istiov1.EnvoyFilter{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+})
+
+var _ = Describe("Orbit controller with a scoped EnvoyFilter", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx   context.Context
+		orbit *orbitv1alpha1.Orbit
+	)
+
+	getEnvoyFilter := func(key types.NamespacedName) (*istiov1.EnvoyFilter, error) {
+		envoyFilter := &istiov1.EnvoyFilter{}
+		err := k8sClient.Get(ctx, key, envoyFilter)
+		return envoyFilter, err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "orbit-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		orbit = &orbitv1alpha1.Orbit{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: ns.Name},
+			Spec: orbitv1alpha1.OrbitSpec{
+				MeshProvider: meshProviderIstio,
+				TrafficRules: orbitv1alpha1.TrafficRulesSpec{
+					Propagate: []orbitv1alpha1.HeaderPropagation{{Name: "x-caller", Value: "frontend"}},
+				},
+				WorkloadSelector: &orbitv1alpha1.WorkloadSelector{Labels: map[string]string{"app": "frontend"}},
+			},
+		}
+	})
+
+	It("selects the workloads of the Orbit", func() {
+		Expect(k8sClient.Create(ctx, orbit)).To(Succeed())
+
+		var envoyFilter *istiov1.EnvoyFilter
+		Eventually(func() error {
+			var err error
+			envoyFilter, err = getEnvoyFilter(types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name})
+			return err
+		}, timeout, interval).Should(Succeed())
+		Expect(envoyFilter.Spec.WorkloadSelector).NotTo(BeNil())
+		Expect(envoyFilter.Spec.WorkloadSelector.Labels).To(Equal(map[string]string{"app": "frontend"}))
+	})
+
+	It("places the EnvoyFilter of a mesh scoped Orbit in the root namespace", func() {
+		orbit.Spec.Scope = orbitv1alpha1.OrbitScopeMesh
+		Expect(k8sClient.Create(ctx, orbit)).To(Succeed())
+
+		meshKey := types.NamespacedName{Namespace: istioRootNamespace, Name: orbit.Namespace + "-" + orbit.Name}
+		var envoyFilter *istiov1.EnvoyFilter
+		Eventually(func() error {
+			var err error
+			envoyFilter, err = getEnvoyFilter(meshKey)
+			return err
+		}, timeout, interval).Should(Succeed())
+		Expect(envoyFilter.Spec.WorkloadSelector.Labels).To(Equal(map[string]string{"app": "frontend"}))
+		Expect(envoyFilter.Labels).To(HaveKeyWithValue(orbitNamespaceLabel, orbit.Namespace))
+		Expect(envoyFilter.OwnerReferences).To(BeEmpty())
+
+		_, err := getEnvoyFilter(types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		latest := &orbitv1alpha1.Orbit{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, latest)).To(Succeed())
+		Expect(latest.Finalizers).To(ContainElement(orbitFinalizer))
+		Expect(latest.Status.EnvoyFilterRef).NotTo(BeNil())
+		Expect(latest.Status.EnvoyFilterRef.Namespace).To(Equal(istioRootNamespace))
+
+		By("deleting the EnvoyFilter along with the Orbit")
+		Expect(k8sClient.Delete(ctx, latest)).To(Succeed())
+		Eventually(func() bool {
+			_, err := getEnvoyFilter(meshKey)
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: orbit.Namespace, Name: orbit.Name}, &orbitv1alpha1.Orbit{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+})
@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+// propagationConfigKey is the ConfigMap key holding the header propagation
+// rules of an Orbit, as a JSON list.
+const propagationConfigKey = "headers.json"
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+// propagationConfig publishes the header propagation rules of an Orbit as a
+// ConfigMap named after it. It serves data planes whose proxies cannot
+// rewrite request headers, leaving propagation to the applications: nothing
+// in the controller reads the ConfigMap, so PropagationSynced is reported as
+// unsupported rather than true.
+type propagationConfig struct {
+	client.Client
+	scheme   *runtime.Scheme
+	log      logr.Logger
+	provider string
+}
+
+func (p *propagationConfig) reconcile(ctx context.Context, orbit *orbitv1alpha1.Orbit, status *orbitv1alpha1.OrbitStatus) error {
+	if err := p.apply(ctx, orbit); err != nil {
+		setCondition(&status.Conditions, orbit.Generation, orbitv1alpha1.OrbitConditionPropagationSynced,
+			metav1.ConditionFalse, reasonSyncFailed, err.Error())
+		return fmt.Errorf("propagation config apply failed: %w", err)
+	}
+
+	status.EnvoyFilterRef = nil
+	setCondition(&status.Conditions, orbit.Generation, orbitv1alpha1.OrbitConditionPropagationSynced,
+		metav1.ConditionFalse, reasonPropagationUnsupported,
+		fmt.Sprintf("%s does not propagate headers, the applications must apply the rules published in ConfigMap %s key %s",
+			p.provider, orbit.Name, propagationConfigKey))
+	return nil
+}
+
+func (p *propagationConfig) finalize(ctx context.Context, orbit *orbitv1alpha1.Orbit) error {
+	configMap := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: orbit.Namespace, Name: orbit.Name}
+	err := p.Get(ctx, key, configMap)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("ConfigMap %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+
+	if !generatedForOrbit(configMap, orbit) {
+		return nil
+	}
+	if err := p.Delete(ctx, configMap); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("ConfigMap %s.%s delete error: %w", key.Name, key.Namespace, err)
+	}
+	return nil
+}
+
+func (p *propagationConfig) apply(ctx context.Context, orbit *orbitv1alpha1.Orbit) error {
+	headers, err := json.Marshal(orbit.Spec.TrafficRules.PropagatedHeaders())
+	if err != nil {
+		return fmt.Errorf("failed to encode propagation rules: %w", err)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      orbit.Name,
+			Namespace: orbit.Namespace,
+			Labels:    orbitLabels(orbit),
+		},
+		Data: map[string]string{propagationConfigKey: string(headers)},
+	}
+	if err := controllerutil.SetControllerReference(orbit, configMap, p.scheme); err != nil {
+		return err
+	}
+
+	current := &corev1.ConfigMap{}
+	err = p.Get(ctx, client.ObjectKeyFromObject(configMap), current)
+	if errors.IsNotFound(err) {
+		if err := p.Create(ctx, configMap); err != nil {
+			return fmt.Errorf("ConfigMap %s.%s create error: %w", configMap.Name, configMap.Namespace, err)
+		}
+		p.log.WithValues("orbit", fmt.Sprintf("%s.%s", orbit.Name, orbit.Namespace)).
+			Info("ConfigMap created", configMap.Name, configMap.Namespace)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("ConfigMap %s.%s get query error: %w", configMap.Name, configMap.Namespace, err)
+	}
+
+	if !generatedForOrbit(current, orbit) {
+		return fmt.Errorf("ConfigMap %s.%s exists and is not managed by this Orbit", configMap.Name, configMap.Namespace)
+	}
+	if equality.Semantic.DeepEqual(configMap.Data, current.Data) {
+		return nil
+	}
+	clone := current.DeepCopy()
+	clone.Data = configMap.Data
+	if err := p.Update(ctx, clone); err != nil {
+		return fmt.Errorf("ConfigMap %s.%s update error: %w", configMap.Name, configMap.Namespace, err)
+	}
+	p.log.WithValues("orbit", fmt.Sprintf("%s.%s", orbit.Name, orbit.Namespace)).
+		Info("ConfigMap updated", configMap.Name, configMap.Namespace)
+	return nil
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	v1 "kubeorbit.io/api/v1"
+	orbitv1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+// MeshProvider renders Orbits and ServiceRoutes into the configuration of
+// one data plane. Providers report what they did through the status they are
+// handed; the reconcilers take care of fetching objects, provider selection
+// and writing the status back.
+type MeshProvider interface {
+	// Name is the spec.provider value served by this provider.
+	Name() string
+
+	// OrbitTypes lists the object types generated for Orbits.
+	OrbitTypes() []client.Object
+	// ReconcileOrbit makes the generated objects match the Orbit spec.
+	ReconcileOrbit(ctx context.Context, orbit *orbitv1alpha1.Orbit, status *orbitv1alpha1.OrbitStatus) error
+	// FinalizeOrbit deletes every object generated for the Orbit.
+	FinalizeOrbit(ctx context.Context, orbit *orbitv1alpha1.Orbit) error
+
+	// ServiceRouteTypes lists the object types generated for ServiceRoutes.
+	ServiceRouteTypes() []client.Object
+	// ReconcileServiceRoute makes the generated objects match the
+	// ServiceRoute spec.
+	ReconcileServiceRoute(ctx context.Context, route *orbitv1alpha1.ServiceRoute, status *orbitv1alpha1.ServiceRouteStatus) error
+	// FinalizeServiceRoute deletes every object generated for the
+	// ServiceRoute.
+	FinalizeServiceRoute(ctx context.Context, route *orbitv1alpha1.ServiceRoute) error
+}
+
+// FinalizingProvider is implemented by providers that change objects which
+// ServiceRoutes cannot own. ServiceRoutes for which FinalizesServiceRoute is
+// true carry a finalizer, so that FinalizeServiceRoute runs before they go
+// away.
+type FinalizingProvider interface {
+	FinalizesServiceRoute(route *orbitv1alpha1.ServiceRoute) bool
+}
+
+// MergingProvider is implemented by providers that merge routes into
+// objects named by spec.merge. ServiceRoutes are reconciled when the
+// object they are merged into, of one of MergeTypes, changes.
+type MergingProvider interface {
+	MergeTypes() []client.Object
+}
+
+// ProviderOptions holds what providers need to talk to the cluster.
+type ProviderOptions struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+
+	// IstioRootNamespace is the Istio root namespace, where EnvoyFilters of
+	// mesh scoped Orbits are placed.
+	IstioRootNamespace string
+	// RouterImage is the orbit-router image deployed by the none provider.
+	RouterImage string
+	// ChannelLabels resolves the pod label holding the channel.
+	ChannelLabels *v1.ChannelLabels
+	// DefaultProvider is the provider of the objects that do not set
+	// spec.provider. NewProviderRegistry sets it.
+	DefaultProvider string
+}
+
+// ProviderFactory creates a MeshProvider.
+type ProviderFactory func(opts ProviderOptions) MeshProvider
+
+var providerFactories = map[string]ProviderFactory{}
+
+// RegisterProvider makes a provider available under name. It is meant to be
+// called from init functions.
+func RegisterProvider(name string, factory ProviderFactory) {
+	if _, ok := providerFactories[name]; ok {
+		panic(fmt.Sprintf("mesh provider %q registered twice", name))
+	}
+	providerFactories[name] = factory
+}
+
+// ProviderRegistry holds the providers enabled in the controller.
+type ProviderRegistry struct {
+	providers       map[string]MeshProvider
+	defaultProvider string
+}
+
+// NewProviderRegistry creates the named providers. defaultProvider is used
+// for objects that leave spec.provider empty.
+func NewProviderRegistry(names []string, defaultProvider string, opts ProviderOptions) (*ProviderRegistry, error) {
+	r := &ProviderRegistry{
+		providers:       map[string]MeshProvider{},
+		defaultProvider: defaultProvider,
+	}
+	opts.DefaultProvider = defaultProvider
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := providerFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown mesh provider %q, known providers: %s",
+				name, strings.Join(KnownProviders(), ", "))
+		}
+		r.providers[name] = factory(opts)
+	}
+	if _, ok := r.providers[defaultProvider]; !ok {
+		return nil, fmt.Errorf("default mesh provider %q is not enabled", defaultProvider)
+	}
+	return r, nil
+}
+
+// Get returns the provider for a spec.provider value.
+func (r *ProviderRegistry) Get(name string) (MeshProvider, error) {
+	if name == "" {
+		name = r.defaultProvider
+	}
+	if p, ok := r.providers[name]; ok {
+		return p, nil
+	}
+	if _, ok := providerFactories[name]; ok {
+		return nil, fmt.Errorf("mesh provider %q is not enabled in the controller", name)
+	}
+	return nil, fmt.Errorf("unknown mesh provider %q, supported providers: %s",
+		name, strings.Join(r.names(), ", "))
+}
+
+// Providers returns the enabled providers sorted by name.
+func (r *ProviderRegistry) Providers() []MeshProvider {
+	providers := make([]MeshProvider, 0, len(r.providers))
+	for _, name := range r.names() {
+		providers = append(providers, r.providers[name])
+	}
+	return providers
+}
+
+func (r *ProviderRegistry) names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KnownProviders returns the names of the registered providers, enabled or
+// not, sorted.
+func KnownProviders() []string {
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// legacyFieldManager is the field manager of the objects written by the
+// releases that created and updated them instead of applying them. Requests
+// without a field manager are recorded under the name of the user agent,
+// which is the name of the controller binary.
+var legacyFieldManager = strings.SplitN(rest.DefaultKubernetesUserAgent(), "/", 2)[0]
+
+// fieldOwner is the field manager of the objects applied by the controller.
+const fieldOwner = client.FieldOwner("kubeorbit")
+
+// serverSideApply applies obj, which must carry its apiVersion and kind,
+// as the kubeorbit field manager. Fields set by other managers are left
+// alone; conflicts on the fields obj sets are forced in its favor.
+func serverSideApply(ctx context.Context, c client.Client, obj client.Object, kind string) (controllerutil.OperationResult, error) {
+	key := client.ObjectKeyFromObject(obj)
+	current := obj.DeepCopyObject().(client.Object)
+	err := c.Get(ctx, key, current)
+	if err != nil && !errors.IsNotFound(err) {
+		return controllerutil.OperationResultNone, fmt.Errorf("%s %s.%s get query error: %w", kind, key.Name, key.Namespace, err)
+	}
+	created := errors.IsNotFound(err)
+	if !created {
+		if err := upgradeManagedFields(ctx, c, current.DeepCopyObject().(client.Object)); err != nil {
+			return controllerutil.OperationResultNone, fmt.Errorf("%s %s.%s managed fields upgrade error: %w", kind, key.Name, key.Namespace, err)
+		}
+	}
+
+	if err := c.Patch(ctx, obj, client.Apply, fieldOwner, client.ForceOwnership); err != nil {
+		return controllerutil.OperationResultNone, fmt.Errorf("%s %s.%s apply error: %w", kind, key.Name, key.Namespace, err)
+	}
+	switch {
+	case created:
+		return controllerutil.OperationResultCreated, nil
+	case obj.GetResourceVersion() != current.GetResourceVersion():
+		return controllerutil.OperationResultUpdated, nil
+	}
+	return controllerutil.OperationResultNone, nil
+}
+
+// upgradeManagedFields moves the fields obj records under legacyFieldManager
+// to the apply entry of fieldOwner. Fields kept by an Update entry are never
+// removed by an apply, so without it the fields the controller stopped
+// setting would stay forever on the objects written by former releases.
+// Objects without legacy entries are left untouched.
+func upgradeManagedFields(ctx context.Context, c client.Client, obj client.Object) error {
+	var (
+		upgraded []metav1.ManagedFieldsEntry
+		legacy   []metav1.ManagedFieldsEntry
+		applied  = -1
+	)
+	for _, entry := range obj.GetManagedFields() {
+		switch {
+		case entry.Manager == legacyFieldManager && entry.Operation == metav1.ManagedFieldsOperationUpdate && entry.Subresource == "":
+			legacy = append(legacy, entry)
+			continue
+		case entry.Manager == string(fieldOwner) && entry.Operation == metav1.ManagedFieldsOperationApply:
+			applied = len(upgraded)
+		}
+		upgraded = append(upgraded, entry)
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	if applied < 0 {
+		applied = len(upgraded)
+		upgraded = append(upgraded, metav1.ManagedFieldsEntry{
+			Manager:    string(fieldOwner),
+			Operation:  metav1.ManagedFieldsOperationApply,
+			APIVersion: legacy[0].APIVersion,
+			Time:       legacy[0].Time,
+			FieldsType: legacy[0].FieldsType,
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte("{}")},
+		})
+	}
+	fields := &fieldpath.Set{}
+	for _, entry := range append(legacy, upgraded[applied]) {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return err
+		}
+		fields = fields.Union(set)
+	}
+	raw, err := fields.ToJSON()
+	if err != nil {
+		return err
+	}
+	upgraded[applied].FieldsV1 = &metav1.FieldsV1{Raw: raw}
+
+	patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+	obj.SetManagedFields(upgraded)
+	return c.Patch(ctx, obj, patch)
+}
+
+// deleteOwned deletes the object of the given kind that is named after owner,
+// if owner controls it.
+func deleteOwned(ctx context.Context, c client.Client, owner client.Object, obj client.Object, kind string) error {
+	key := client.ObjectKeyFromObject(owner)
+	err := c.Get(ctx, key, obj)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s %s.%s get query error: %w", kind, key.Name, key.Namespace, err)
+	}
+
+	if !metav1.IsControlledBy(obj, owner) {
+		return nil
+	}
+	if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("%s %s.%s delete error: %w", kind, key.Name, key.Namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	v1 "kubeorbit.io/api/v1"
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+	"kubeorbit.io/pkg/router"
+)
+
+const (
+	routerContainerName = "orbit-router"
+	// defaultRouterProbePort is the preferred port of the router health
+	// probes.
+	defaultRouterProbePort = 9091
+)
+
+func init() {
+	RegisterProvider(router.MeshProvider, newRouterProvider)
+}
+
+//+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// routerProvider routes ServiceRoutes without a service mesh. It deploys an
+// orbit-router in front of the routed Service and points the Service at it;
+// the router sends each request to a Service selecting the subset pods.
+//
+// Orbits need no generated objects: the router sets the headers of the
+// Orbits selecting the subset pods on the requests it sends them, with
+// their literal value or the channel of the subset, and the applications
+// propagate the others.
+type routerProvider struct {
+	routeObjects
+	image           string
+	channelLabels   *v1.ChannelLabels
+	defaultProvider string
+}
+
+func newRouterProvider(opts ProviderOptions) MeshProvider {
+	return &routerProvider{
+		routeObjects: routeObjects{
+			Client:   opts.Client,
+			scheme:   opts.Scheme,
+			log:      opts.Log,
+			provider: router.MeshProvider,
+		},
+		image:           opts.RouterImage,
+		channelLabels:   opts.ChannelLabels,
+		defaultProvider: opts.DefaultProvider,
+	}
+}
+
+func (p *routerProvider) Name() string {
+	return router.MeshProvider
+}
+
+func (p *routerProvider) OrbitTypes() []client.Object {
+	return nil
+}
+
+func (p *routerProvider) ReconcileOrbit(ctx context.Context, orbit *routev1alpha1.Orbit, status *routev1alpha1.OrbitStatus) error {
+	status.EnvoyFilterRef = nil
+	setCondition(&status.Conditions, orbit.Generation, routev1alpha1.OrbitConditionPropagationSynced,
+		metav1.ConditionTrue, reasonSynced, "literal and channel headers are set by orbit-router, the applications propagate the others")
+	return nil
+}
+
+func (p *routerProvider) FinalizeOrbit(ctx context.Context, orbit *routev1alpha1.Orbit) error {
+	return nil
+}
+
+func (p *routerProvider) ServiceRouteTypes() []client.Object {
+	return []client.Object{
+		&corev1.Service{},
+		&corev1.ServiceAccount{},
+		&rbacv1.Role{},
+		&rbacv1.RoleBinding{},
+		&appsv1.Deployment{},
+	}
+}
+
+// FinalizesServiceRoute is true as the routed Service selector must be
+// given back before the router goes away.
+func (p *routerProvider) FinalizesServiceRoute(_ *routev1alpha1.ServiceRoute) bool {
+	return true
+}
+
+func (p *routerProvider) ReconcileServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	if err := unsupportedRoutePolicy(route, p.provider); err != nil {
+		return err
+	}
+	key, err := localService(route, p.provider)
+	if err != nil {
+		return err
+	}
+	target := &corev1.Service{}
+	if err := p.Get(ctx, key, target); err != nil {
+		return fmt.Errorf("Service %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+	if err := p.reconcileSubsetServices(ctx, route, target); err != nil {
+		return err
+	}
+	if err := p.reconcileAccess(ctx, route); err != nil {
+		return err
+	}
+
+	deployment, err := p.reconcileDeployment(ctx, route, target)
+	if err != nil {
+		return err
+	}
+	if deployment.Status.AvailableReplicas == 0 {
+		setCondition(&status.Conditions, route.Generation, routev1alpha1.ServiceRouteConditionRouterAvailable,
+			metav1.ConditionFalse, reasonNotReady, "waiting for the router deployment to become available")
+		return nil
+	}
+	setCondition(&status.Conditions, route.Generation, routev1alpha1.ServiceRouteConditionRouterAvailable,
+		metav1.ConditionTrue, reasonReady, "")
+
+	return p.takeOver(ctx, route, target)
+}
+
+func (p *routerProvider) FinalizeServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute) error {
+	// Services of other namespaces are never taken over.
+	target := &corev1.Service{}
+	key := client.ObjectKey{Namespace: route.Namespace, Name: route.GetServiceName()}
+	if err := p.Get(ctx, key, target); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("Service %s.%s get query error: %w", key.Name, key.Namespace, err)
+	} else if err == nil {
+		if err := p.giveBack(ctx, route, target); err != nil {
+			return err
+		}
+	}
+
+	for _, list := range []client.ObjectList{
+		&appsv1.DeploymentList{},
+		&rbacv1.RoleBindingList{},
+		&rbacv1.RoleList{},
+		&corev1.ServiceAccountList{},
+		&corev1.ServiceList{},
+	} {
+		if err := p.prune(ctx, route, list, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func routerName(route *routev1alpha1.ServiceRoute) string {
+	return route.Name + "-router"
+}
+
+func routerPodLabels(route *routev1alpha1.ServiceRoute) map[string]string {
+	return map[string]string{router.PodLabel: route.Name}
+}
+
+// reconcileAccess lets the router read what it routes by.
+func (p *routerProvider) reconcileAccess(ctx context.Context, route *routev1alpha1.ServiceRoute) error {
+	name := routerName(route)
+	objectMeta := metav1.ObjectMeta{Name: name, Namespace: route.Namespace}
+
+	account := &corev1.ServiceAccount{ObjectMeta: objectMeta}
+	if err := p.apply(ctx, route, account, "ServiceAccount", func() {}); err != nil {
+		return err
+	}
+
+	role := &rbacv1.Role{ObjectMeta: objectMeta}
+	if err := p.apply(ctx, route, role, "Role", func() {
+		role.Rules = []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{routev1alpha1.GroupVersion.Group},
+				Resources: []string{"serviceroutes", "orbits"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"services", "endpoints"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+		}
+	}); err != nil {
+		return err
+	}
+
+	binding := &rbacv1.RoleBinding{ObjectMeta: objectMeta}
+	return p.apply(ctx, route, binding, "RoleBinding", func() {
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name}
+		binding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: route.Namespace}}
+	})
+}
+
+func (p *routerProvider) reconcileDeployment(ctx context.Context, route *routev1alpha1.ServiceRoute, target *corev1.Service) (*appsv1.Deployment, error) {
+	ports := make([]corev1.ContainerPort, 0, len(target.Spec.Ports))
+	listenPorts := make([]string, 0, len(target.Spec.Ports))
+	listening := make(map[int32]bool, len(target.Spec.Ports))
+	for _, port := range target.Spec.Ports {
+		listenPort := router.ListenPort(port)
+		ports = append(ports, corev1.ContainerPort{
+			Name:          port.TargetPort.StrVal,
+			ContainerPort: listenPort,
+			Protocol:      port.Protocol,
+		})
+		listenPorts = append(listenPorts, strconv.Itoa(int(listenPort)))
+		listening[listenPort] = true
+	}
+	probePort := routerProbePort(listening)
+	channelLabel := v1.KUBEORBIT_CHANNEL_LABEL
+	if p.channelLabels != nil {
+		label, err := p.channelLabels.For(ctx, route.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		channelLabel = label
+	}
+	args := []string{
+		"--namespace=" + route.Namespace,
+		"--service-route=" + route.Name,
+		"--listen-ports=" + strings.Join(listenPorts, ","),
+		"--channel-label=" + channelLabel,
+		"--default-provider=" + p.defaultProvider,
+		fmt.Sprintf("--health-probe-bind-address=:%d", probePort),
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: routerName(route), Namespace: route.Namespace}}
+	err := p.apply(ctx, route, deployment, "Deployment", func() {
+		if deployment.ResourceVersion == "" {
+			deployment.Spec = routerDeploymentSpec(route, p.image)
+		}
+		container := &deployment.Spec.Template.Spec.Containers[0]
+		container.Image = p.image
+		container.Args = args
+		container.Ports = ports
+		container.LivenessProbe = routerProbe("/healthz", probePort)
+		container.ReadinessProbe = routerProbe("/readyz", probePort)
+	})
+	return deployment, err
+}
+
+func routerDeploymentSpec(route *routev1alpha1.ServiceRoute, image string) appsv1.DeploymentSpec {
+	replicas := int32(1)
+	allowPrivilegeEscalation := false
+	runAsNonRoot := true
+
+	return appsv1.DeploymentSpec{
+		Replicas: &replicas,
+		Selector: &metav1.LabelSelector{MatchLabels: routerPodLabels(route)},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: routerPodLabels(route)},
+			Spec: corev1.PodSpec{
+				ServiceAccountName: routerName(route),
+				SecurityContext:    &corev1.PodSecurityContext{RunAsNonRoot: &runAsNonRoot},
+				Containers: []corev1.Container{{
+					Name:            routerContainerName,
+					Image:           image,
+					SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &allowPrivilegeEscalation},
+				}},
+			},
+		},
+	}
+}
+
+// routerProbePort returns the port of the router health probes: the first
+// port from defaultRouterProbePort the router does not route traffic on.
+func routerProbePort(listening map[int32]bool) int32 {
+	port := int32(defaultRouterProbePort)
+	for listening[port] {
+		port++
+	}
+	return port
+}
+
+func routerProbe(path string, port int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt(int(port))},
+		},
+	}
+}
+
+// apply creates obj or updates it with the fields set by mutate, which runs
+// on the stored object when there is one.
+func (p *routerProvider) apply(ctx context.Context, route *routev1alpha1.ServiceRoute, obj client.Object, kind string, mutate func()) error {
+	result, err := controllerutil.CreateOrUpdate(ctx, p.Client, obj, func() error {
+		if obj.GetResourceVersion() != "" && !metav1.IsControlledBy(obj, route) {
+			return fmt.Errorf("%s %s.%s exists and is not managed by this ServiceRoute", kind, obj.GetName(), obj.GetNamespace())
+		}
+		obj.SetLabels(p.labels(route))
+		mutate()
+		return controllerutil.SetControllerReference(route, obj, p.scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("%s %s.%s apply error: %w", kind, obj.GetName(), obj.GetNamespace(), err)
+	}
+	if result != controllerutil.OperationResultNone {
+		p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", route.Name, route.Namespace)).
+			Info(fmt.Sprintf("%s %s", kind, result), obj.GetName(), obj.GetNamespace())
+	}
+	return nil
+}
+
+// takeOver points the routed Service at the router, keeping its selector in
+// an annotation.
+func (p *routerProvider) takeOver(ctx context.Context, route *routev1alpha1.ServiceRoute, target *corev1.Service) error {
+	if equality.Semantic.DeepEqual(target.Spec.Selector, routerPodLabels(route)) {
+		return nil
+	}
+	if _, ok := target.Annotations[router.SelectorAnnotation]; ok {
+		return fmt.Errorf("Service %s.%s is routed by another router", target.Name, target.Namespace)
+	}
+
+	selector, err := json.Marshal(target.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("failed to encode Service selector: %w", err)
+	}
+	clone := target.DeepCopy()
+	if clone.Annotations == nil {
+		clone.Annotations = map[string]string{}
+	}
+	clone.Annotations[router.SelectorAnnotation] = string(selector)
+	clone.Spec.Selector = routerPodLabels(route)
+	if err := p.Update(ctx, clone); err != nil {
+		return fmt.Errorf("Service %s.%s update error: %w", target.Name, target.Namespace, err)
+	}
+	p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", route.Name, route.Namespace)).
+		Info("Service taken over by router", target.Name, target.Namespace)
+	return nil
+}
+
+// giveBack restores the selector of a Service taken over by the router of
+// the ServiceRoute.
+func (p *routerProvider) giveBack(ctx context.Context, route *routev1alpha1.ServiceRoute, target *corev1.Service) error {
+	if _, ok := target.Annotations[router.SelectorAnnotation]; !ok ||
+		!equality.Semantic.DeepEqual(target.Spec.Selector, routerPodLabels(route)) {
+		return nil
+	}
+	selector, err := router.AppSelector(target)
+	if err != nil {
+		return err
+	}
+
+	clone := target.DeepCopy()
+	delete(clone.Annotations, router.SelectorAnnotation)
+	clone.Spec.Selector = selector
+	if err := p.Update(ctx, clone); err != nil {
+		return fmt.Errorf("Service %s.%s update error: %w", target.Name, target.Namespace, err)
+	}
+	p.log.WithValues("serviceroute", fmt.Sprintf("%s.%s", route.Name, route.Namespace)).
+		Info("Service selector restored", target.Name, target.Namespace)
+	return nil
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import "testing"
+
+func TestRouterProbePort(t *testing.T) {
+	tests := []struct {
+		name      string
+		listening map[int32]bool
+		want      int32
+	}{
+		{name: "no listen port", want: 9091},
+		{name: "other listen port", listening: map[int32]bool{8080: true}, want: 9091},
+		{name: "probe port taken", listening: map[int32]bool{9091: true}, want: 9092},
+		{name: "next ports taken", listening: map[int32]bool{9091: true, 9092: true, 9094: true}, want: 9093},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := routerProbePort(tt.listening); got != tt.want {
+				t.Errorf("routerProbePort() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,35 +19,54 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"log"
+	"sort"
+	"strings"
 
 	"github.com/go-logr/logr"
-	"github.com/google/go-cmp/cmp"
-	"github.com/google/go-cmp/cmp/cmpopts"
-	"istio.io/api/networking/v1alpha3"
-	istiov1 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	v1 "kubeorbit.io/api/v1"
 	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+	"kubeorbit.io/pkg/router"
 )
 
+// serviceNamespaceField indexes ServiceRoutes by the namespace of the
+// Service they route, which may differ from their own.
+const serviceNamespaceField = "spec.serviceNamespace"
+
+// serviceRouteFinalizer guards the cleanup of the providers that change
+// objects the ServiceRoute does not own.
+const serviceRouteFinalizer = "network.kubeorbit.io/router"
+
 // ServiceRouteReconciler reconciles a ServiceRoute object
 type ServiceRouteReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Providers *ProviderRegistry
+	// ChannelLabels resolves the pod label channels are discovered by.
+	ChannelLabels *v1.ChannelLabels
 }
 
 //+kubebuilder:rbac:groups=network.kubeorbit.io,resources=serviceroutes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=network.kubeorbit.io,resources=serviceroutes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=network.kubeorbit.io,resources=serviceroutes/finalizers,verbs=update
-//+kubebuilder:rbac:groups=networking.istio.io,resources=virtualservices,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=networking.istio.io,resources=destinationrules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -59,211 +78,466 @@ type ServiceRouteReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
 func (r *ServiceRouteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = r.Log.WithValues("serviceroute", req.NamespacedName)
+	logger := r.Log.WithValues("serviceroute", req.NamespacedName)
 	obj := &routev1alpha1.ServiceRoute{}
 
 	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
-		log.Println(err, "unable to fetch object")
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable to fetch object")
+		return ctrl.Result{}, err
+	}
+	if !obj.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(obj, serviceRouteFinalizer) {
+			for _, p := range r.Providers.Providers() {
+				if err := p.FinalizeServiceRoute(ctx, obj); err != nil {
+					return ctrl.Result{}, fmt.Errorf("%s FinalizeServiceRoute failed: %w", p.Name(), err)
+				}
+			}
+			controllerutil.RemoveFinalizer(obj, serviceRouteFinalizer)
+			if err := r.Update(ctx, obj); err != nil {
+				return ctrl.Result{}, fmt.Errorf("ServiceRoute %s.%s finalizer removal error: %w", obj.Name, obj.Namespace, err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	status := obj.Status.DeepCopy()
+	previous := status.Conditions
+	status.Conditions = nil
+	generation := obj.Generation
+	var reconcileErr error
+
+	// Subsets are counted first: providers leave fallback routes out
+	// while their subsets have no ready endpoint.
+	if err := r.reconcileSubsetStatus(ctx, obj, status); err != nil {
+		return ctrl.Result{}, fmt.Errorf("reconcileSubsetStatus failed: %w", err)
+	}
+
+	conflict, err := r.findConflict(ctx, obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if conflict != nil {
+		setCondition(&status.Conditions, generation, routev1alpha1.ServiceRouteConditionConflicted,
+			metav1.ConditionTrue, reasonHostConflict, fmt.Sprintf("host %s is routed by ServiceRoute %s.%s",
+				obj.GetHost(), conflict.Name, conflict.Namespace))
 	} else {
-		if err := r.reconcileDestinationRule(obj, req); err != nil {
-			return ctrl.Result{}, fmt.Errorf("reconcileDestinatinRule failed: %w", err)
+		setCondition(&status.Conditions, generation, routev1alpha1.ServiceRouteConditionConflicted,
+			metav1.ConditionFalse, reasonNoConflict, "")
+	}
+
+	provider, err := r.Providers.Get(obj.Spec.MeshProvider)
+	if err != nil {
+		setCondition(&status.Conditions, generation, routev1alpha1.ServiceRouteConditionProviderSupported,
+			metav1.ConditionFalse, reasonUnsupportedProvider, err.Error())
+	} else {
+		setCondition(&status.Conditions, generation, routev1alpha1.ServiceRouteConditionProviderSupported,
+			metav1.ConditionTrue, reasonProviderSupported, "")
+	}
+
+	// Every provider but the selected one is finalized, so that switching
+	// to another provider, or to one that is disabled or unsupported,
+	// removes what the previous one generated. Conflicted ServiceRoutes
+	// render nothing, so that the older one alone routes the host.
+	for _, p := range r.Providers.Providers() {
+		if p == provider && conflict == nil {
+			continue
+		}
+		if err := p.FinalizeServiceRoute(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("%s FinalizeServiceRoute failed: %w", p.Name(), err)
 		}
+	}
+
+	// The finalizer is added before the provider changes anything, and
+	// dropped only once the other providers are finalized and the provider
+	// reconciled, so that switching away from a provider or a mode that
+	// needs it still cleans up.
+	finalizing, ok := provider.(FinalizingProvider)
+	needsFinalizer := conflict == nil && ok && finalizing.FinalizesServiceRoute(obj)
+	if needsFinalizer && !controllerutil.ContainsFinalizer(obj, serviceRouteFinalizer) {
+		controllerutil.AddFinalizer(obj, serviceRouteFinalizer)
+		if err := r.Update(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("ServiceRoute %s.%s finalizer update error: %w", obj.Name, obj.Namespace, err)
+		}
+	}
 
-		if err := r.reconcileVirtualService(obj, req); err != nil {
-			return ctrl.Result{}, fmt.Errorf("reconcileVirtualService failed: %w", err)
+	var providerErr error
+	if provider != nil && conflict == nil {
+		route := obj.WithDiscoveredSubsets(status.Channels, status.ChannelLabel)
+		providerErr = provider.ReconcileServiceRoute(ctx, route, status)
+		if providerErr != nil && reconcileErr == nil {
+			reconcileErr = fmt.Errorf("%s ReconcileServiceRoute failed: %w", provider.Name(), providerErr)
 		}
 	}
 
-	return ctrl.Result{}, nil
+	if providerErr == nil && !needsFinalizer && controllerutil.ContainsFinalizer(obj, serviceRouteFinalizer) {
+		controllerutil.RemoveFinalizer(obj, serviceRouteFinalizer)
+		if err := r.Update(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("ServiceRoute %s.%s finalizer update error: %w", obj.Name, obj.Namespace, err)
+		}
+	}
+
+	setReadyCondition(&status.Conditions, generation, routev1alpha1.ServiceRouteConditionReady,
+		routev1alpha1.ServiceRouteConditionConflicted)
+	if reconcileErr != nil {
+		setCondition(&status.Conditions, generation, routev1alpha1.ServiceRouteConditionReady,
+			metav1.ConditionFalse, reasonSyncFailed, reconcileErr.Error())
+	}
+	keepTransitionTimes(status.Conditions, previous)
+	status.ObservedGeneration = generation
+
+	if !equality.Semantic.DeepEqual(status, &obj.Status) {
+		obj.Status = *status
+		if err := r.Status().Update(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("ServiceRoute %s.%s status update error: %w", obj.Name, obj.Namespace, err)
+		}
+	}
+
+	return ctrl.Result{}, reconcileErr
 }
 
-func (r *ServiceRouteReconciler) reconcileDestinationRule(tr *routev1alpha1.ServiceRoute, req ctrl.Request) error {
-	svcName := tr.GetServiceName()
-	newSpec := v1alpha3.DestinationRule{
-		Host:    svcName,
-		Subsets: buildRoute(tr),
-	}
-
-	destinationRule := &istiov1.DestinationRule{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      tr.Name,
-			Namespace: tr.Namespace,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(tr, schema.GroupVersionKind{
-					Group:   tr.GroupVersionKind().Group,
-					Version: tr.GroupVersionKind().Version,
-					Kind:    tr.Kind,
-				}),
-			},
-		},
-		Spec: newSpec,
-	}
-
-	err := r.Get(context.TODO(), req.NamespacedName, destinationRule)
-	if errors.IsNotFound(err) {
-		err = r.Create(context.TODO(), destinationRule)
-		if err != nil {
-			return fmt.Errorf("DestinationRule %s.%s create error: %w", svcName, tr.Namespace, err)
+// hostKey identifies the host routed by a ServiceRoute: the Service behind
+// it, or the host itself.
+func hostKey(tr *routev1alpha1.ServiceRoute) string {
+	if key, ok := tr.GetServiceKey(); ok {
+		return key.String()
+	}
+	return tr.GetHost()
+}
+
+// findConflict returns the oldest other ServiceRoute routing the host of
+// obj, if it is older than obj. ServiceRoutes merged into VirtualServices
+// conflict as well: each of them would define the subsets of the host in a
+// DestinationRule of its own, and Istio only uses one DestinationRule per
+// host.
+func (r *ServiceRouteReconciler) findConflict(ctx context.Context, obj *routev1alpha1.ServiceRoute) (*routev1alpha1.ServiceRoute, error) {
+	routes := &routev1alpha1.ServiceRouteList{}
+	if err := r.List(ctx, routes); err != nil {
+		return nil, fmt.Errorf("ServiceRoute list query error: %w", err)
+	}
+
+	var oldest *routev1alpha1.ServiceRoute
+	for i := range routes.Items {
+		other := &routes.Items[i]
+		if other.UID == obj.UID || !other.DeletionTimestamp.IsZero() || hostKey(other) != hostKey(obj) {
+			continue
+		}
+		if createdBefore(other, obj) && (oldest == nil || createdBefore(other, oldest)) {
+			oldest = other
+		}
+	}
+	return oldest, nil
+}
+
+// createdBefore orders ServiceRoutes by creation, then by namespace and
+// name.
+func createdBefore(a, b *routev1alpha1.ServiceRoute) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// sameHostServiceRoutes maps a ServiceRoute to the other ServiceRoutes
+// routing its host, whose conflicts it may settle.
+func (r *ServiceRouteReconciler) sameHostServiceRoutes(obj client.Object) []reconcile.Request {
+	changed, ok := obj.(*routev1alpha1.ServiceRoute)
+	if !ok {
+		return nil
+	}
+	routes := &routev1alpha1.ServiceRouteList{}
+	if err := r.List(context.Background(), routes); err != nil {
+		r.Log.Error(err, "unable to list ServiceRoutes")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for i := range routes.Items {
+		if routes.Items[i].UID != changed.UID && hostKey(&routes.Items[i]) == hostKey(changed) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&routes.Items[i])})
 		}
-		r.Log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
-			Info("DestinationRule created", destinationRule.GetName(), tr.Namespace)
+	}
+	return requests
+}
+
+// reconcileSubsetStatus counts the pods of the routed Service that carry
+// the labels of each subset, and those of them that are ready endpoints of
+// the routed Service or of a subset Service, so that a subset is only
+// reported ready once it can take traffic. Hosts naming no Service, such
+// as ServiceEntry hosts, report no subsets.
+func (r *ServiceRouteReconciler) reconcileSubsetStatus(ctx context.Context, obj *routev1alpha1.ServiceRoute, status *routev1alpha1.ServiceRouteStatus) error {
+	key, ok := obj.GetServiceKey()
+	if !ok {
+		status.Subsets = nil
+		status.Channels = nil
+		status.ChannelLabel = ""
+		return nil
+	}
+	target := &corev1.Service{}
+	if err := r.Get(ctx, key, target); errors.IsNotFound(err) {
+		status.Subsets = nil
+		status.Channels = nil
+		status.ChannelLabel = ""
+		setCondition(&status.Conditions, obj.Generation, routev1alpha1.ServiceRouteConditionSubsetsAvailable,
+			metav1.ConditionFalse, reasonServiceNotFound, fmt.Sprintf("Service %s.%s not found", key.Name, key.Namespace))
 		return nil
 	} else if err != nil {
-		return fmt.Errorf("DestinationRule %s.%s get query error: %w", tr.Name, tr.Namespace, err)
+		return fmt.Errorf("Service %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+
+	selector, err := router.AppSelector(target)
+	if err != nil {
+		return err
+	}
+	pods := &corev1.PodList{}
+	if len(selector) > 0 {
+		if err := r.List(ctx, pods, client.InNamespace(key.Namespace), client.MatchingLabels(selector)); err != nil {
+			return fmt.Errorf("Pod list query error: %w", err)
+		}
 	}
 
-	if destinationRule != nil {
-		if diff := cmp.Diff(newSpec, destinationRule.Spec); diff != "" {
-			clone := destinationRule.DeepCopy()
-			clone.Spec = newSpec
-			err = r.Update(context.TODO(), clone)
-			if err != nil {
-				return fmt.Errorf("DestinationRule %s.%s update error: %w", tr.Name, tr.Namespace, err)
+	status.ChannelLabel = ""
+	if d := obj.Spec.TrafficRoutes.Discovery; d != nil {
+		label, err := r.ChannelLabels.For(ctx, key.Namespace)
+		if err != nil {
+			return err
+		}
+		status.ChannelLabel = d.ChannelLabel(label)
+	}
+	status.Channels = discoverChannels(status.ChannelLabel, pods.Items)
+	subsets := routeSubsets(obj.WithDiscoveredSubsets(status.Channels, status.ChannelLabel))
+	readyPods, err := r.readyEndpointPods(ctx, key, subsets)
+	if err != nil {
+		return err
+	}
+	status.Subsets = make([]routev1alpha1.SubsetStatus, 0, len(subsets))
+	unavailable := make([]string, 0)
+	for _, subset := range subsets {
+		s := routev1alpha1.SubsetStatus{Name: subset.Name}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !labels.SelectorFromSet(subset.Labels).Matches(labels.Set(pod.Labels)) {
+				continue
 			}
-			r.Log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Name, tr.Namespace)).
-				Info("DestinationRule updated", destinationRule.GetName(), tr.Namespace)
+			s.Endpoints++
+			if readyPods[pod.Name] {
+				s.ReadyEndpoints++
+			}
+		}
+		if s.ReadyEndpoints == 0 {
+			unavailable = append(unavailable, subset.Name)
 		}
+		status.Subsets = append(status.Subsets, s)
 	}
 
+	if len(unavailable) > 0 {
+		setCondition(&status.Conditions, obj.Generation, routev1alpha1.ServiceRouteConditionSubsetsAvailable,
+			metav1.ConditionFalse, reasonNoReadyEndpoints, "subsets without ready endpoints: "+strings.Join(unavailable, ", "))
+	} else {
+		setCondition(&status.Conditions, obj.Generation, routev1alpha1.ServiceRouteConditionSubsetsAvailable,
+			metav1.ConditionTrue, reasonEndpointsReady, "")
+	}
 	return nil
 }
 
-func (r *ServiceRouteReconciler) reconcileVirtualService(tr *routev1alpha1.ServiceRoute, req ctrl.Request) error {
-	svcName := tr.GetServiceName()
-
-	newSpec := v1alpha3.VirtualService{
-		Hosts: []string{
-			svcName,
-		},
-		Http: buildHTTP(tr),
-	}
-
-	virtualService := &istiov1.VirtualService{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      tr.Name,
-			Namespace: tr.Namespace,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(tr, schema.GroupVersionKind{
-					Group:   tr.GroupVersionKind().Group,
-					Version: tr.GroupVersionKind().Version,
-					Kind:    tr.Kind,
-				}),
-			},
-		},
-		Spec: newSpec,
-	}
-
-	err := r.Get(context.TODO(), req.NamespacedName, virtualService)
-	if errors.IsNotFound(err) {
-		err = r.Create(context.TODO(), virtualService)
-		if err != nil {
-			return fmt.Errorf("VirtualService %s.%s create error: %w", tr.Name, tr.Namespace, err)
-		}
-		r.Log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Spec.Name, tr.Namespace)).
-			Info("VirtualService created", virtualService.GetName(), tr.Namespace)
+// discoverChannels returns the sorted channels found in the label of the
+// pods, nil when label is empty as discovery is disabled. Terminating pods
+// are left out, so that a channel goes away with its last pod. Channels
+// that cannot name a subset Service are skipped.
+func discoverChannels(label string, pods []corev1.Pod) []string {
+	if label == "" {
 		return nil
-	} else if err != nil {
-		return fmt.Errorf("VirtualService %s.%s get query error %v", tr.Name, tr.Namespace, err)
-	}
-
-	if virtualService != nil {
-		if diff := cmp.Diff(
-			newSpec,
-			virtualService.Spec,
-			cmpopts.IgnoreFields(v1alpha3.HTTPRoute{}, "Mirror", "MirrorPercentage"),
-		); diff != "" {
-			vtClone := virtualService.DeepCopy()
-			vtClone.Spec = newSpec
-			err = r.Update(context.TODO(), vtClone)
-			if err != nil {
-				return fmt.Errorf("VirtualService %s.%s update error: %w", tr.Name, tr.Namespace, err)
-			}
-			r.Log.WithValues("serviceroute", fmt.Sprintf("%s.%s", tr.Spec.Name, tr.Namespace)).
-				Info("VirtualService updated", virtualService.GetName(), tr.Namespace)
+	}
+	found := map[string]bool{}
+	for i := range pods {
+		channel, ok := pods[i].Labels[label]
+		if !ok || pods[i].DeletionTimestamp != nil || len(validation.IsDNS1123Label(channel)) > 0 {
+			continue
 		}
+		found[channel] = true
 	}
 
-	return nil
+	channels := make([]string, 0, len(found))
+	for channel := range found {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	if len(channels) == 0 {
+		return nil
+	}
+	return channels
 }
 
-func buildRoute(tr *routev1alpha1.ServiceRoute) []*v1alpha3.Subset {
-	defaultRoute := tr.Spec.TrafficRoutes.Default
-	subsets := make([]*v1alpha3.Subset, 0)
+// readyEndpointPods returns the names of the pods that are ready endpoints
+// of the routed Service or of its subset Services. The routed Service alone
+// does not do, as the none provider points it at the router.
+func (r *ServiceRouteReconciler) readyEndpointPods(ctx context.Context, key client.ObjectKey, subsets []routeSubset) (map[string]bool, error) {
+	services := map[string]bool{key.Name: true}
+	for _, subset := range subsets {
+		services[router.SubsetServiceName(key.Name, subset.Name)] = true
+	}
 
-	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
-		if c.Labels != nil {
-			subsets = append(subsets, &v1alpha3.Subset{
-				Name:   c.Name,
-				Labels: c.Labels,
-			})
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := r.List(ctx, slices, client.InNamespace(key.Namespace)); err != nil {
+		return nil, fmt.Errorf("EndpointSlice list query error: %w", err)
+	}
+	ready := map[string]bool{}
+	for _, slice := range slices.Items {
+		if !services[slice.Labels[discoveryv1.LabelServiceName]] {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			// A missing condition means ready.
+			if ep.TargetRef == nil || ep.TargetRef.Kind != "Pod" ||
+				ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			ready[ep.TargetRef.Name] = true
 		}
 	}
+	return ready, nil
+}
 
-	for _, c := range defaultRoute {
-		if c != "" {
-			subsets = append(subsets, &v1alpha3.Subset{
-				Name:   c,
-				Labels: defaultRoute,
-			})
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceRouteReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &routev1alpha1.ServiceRoute{}, serviceNamespaceField,
+		func(obj client.Object) []string {
+			if key, ok := obj.(*routev1alpha1.ServiceRoute).GetServiceKey(); ok {
+				return []string{key.Namespace}
+			}
+			return nil
+		}); err != nil {
+		return fmt.Errorf("ServiceRoute %s index error: %w", serviceNamespaceField, err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&routev1alpha1.ServiceRoute{}).
+		Watches(&source.Kind{Type: &routev1alpha1.ServiceRoute{}}, handler.EnqueueRequestsFromMapFunc(r.sameHostServiceRoutes)).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.podToServiceRoutes)).
+		Watches(&source.Kind{Type: &discoveryv1.EndpointSlice{}}, handler.EnqueueRequestsFromMapFunc(r.endpointSliceToServiceRoutes)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceToServiceRoutes))
+	for _, p := range r.Providers.Providers() {
+		for _, t := range p.ServiceRouteTypes() {
+			b = b.Owns(t)
+		}
+		if merging, ok := p.(MergingProvider); ok {
+			for _, t := range merging.MergeTypes() {
+				b = b.Watches(&source.Kind{Type: t}, handler.EnqueueRequestsFromMapFunc(r.mergeTargetToServiceRoutes))
+			}
 		}
 	}
+	return b.Complete(r)
+}
 
-	return subsets
+// mergeTargetToServiceRoutes maps an object to the ServiceRoutes of its
+// namespace merged into it.
+func (r *ServiceRouteReconciler) mergeTargetToServiceRoutes(obj client.Object) []reconcile.Request {
+	routes := &routev1alpha1.ServiceRouteList{}
+	if err := r.List(context.Background(), routes, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list ServiceRoutes", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for i := range routes.Items {
+		if m := routes.Items[i].Spec.Merge; m != nil && m.VirtualService == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&routes.Items[i])})
+		}
+	}
+	return requests
 }
 
-func buildHTTP(tr *routev1alpha1.ServiceRoute) []*v1alpha3.HTTPRoute {
-	httpRoutes := make([]*v1alpha3.HTTPRoute, 0)
-	defaultRoute := tr.Spec.TrafficRoutes.Default
+// podToServiceRoutes maps a Pod to the ServiceRoutes routing a Service of its
+// namespace with a subset it belongs to, or discovering the channel it
+// carries.
+func (r *ServiceRouteReconciler) podToServiceRoutes(obj client.Object) []reconcile.Request {
+	routes, err := r.serviceRoutesOfNamespace(obj.GetNamespace())
+	if err != nil {
+		r.Log.Error(err, "unable to list ServiceRoutes", "namespace", obj.GetNamespace())
+		return nil
+	}
 
-	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
-		if c.Labels != nil {
-			headers := make(map[string]*v1alpha3.StringMatch)
-			for k, match := range c.Headers {
-				headers[k] = &v1alpha3.StringMatch{
-					MatchType: &v1alpha3.StringMatch_Exact{Exact: match.Exact},
-				}
+	label, err := r.ChannelLabels.For(context.Background(), obj.GetNamespace())
+	if err != nil {
+		r.Log.Error(err, "unable to resolve the channel label", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for i := range routes.Items {
+		if d := routes.Items[i].Spec.TrafficRoutes.Discovery; d != nil {
+			if _, ok := obj.GetLabels()[d.ChannelLabel(label)]; ok {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&routes.Items[i])})
+				continue
 			}
-			httpRoutes = append(httpRoutes, &v1alpha3.HTTPRoute{
-				Match: []*v1alpha3.HTTPMatchRequest{
-					{
-						Headers: headers,
-					},
-				},
-				Route: []*v1alpha3.HTTPRouteDestination{
-					{
-						Destination: &v1alpha3.Destination{
-							Host:   tr.Spec.Name,
-							Subset: c.Name,
-						},
-					},
-				},
-			})
 		}
+		for _, subset := range routeSubsets(&routes.Items[i]) {
+			if labels.SelectorFromSet(subset.Labels).Matches(labels.Set(obj.GetLabels())) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&routes.Items[i])})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// namespaceToServiceRoutes maps a Namespace to the ServiceRoutes discovering
+// channels on its pods, whose channel label it may override.
+func (r *ServiceRouteReconciler) namespaceToServiceRoutes(obj client.Object) []reconcile.Request {
+	routes, err := r.serviceRoutesOfNamespace(obj.GetName())
+	if err != nil {
+		r.Log.Error(err, "unable to list ServiceRoutes", "namespace", obj.GetName())
+		return nil
 	}
 
-	for _, c := range defaultRoute {
-		if c != "" {
-			httpRoutes = append(httpRoutes, &v1alpha3.HTTPRoute{
-				Route: []*v1alpha3.HTTPRouteDestination{
-					{
-						Destination: &v1alpha3.Destination{
-							Host:   tr.Spec.Name,
-							Subset: c,
-						},
-					},
-				},
-			})
+	requests := make([]reconcile.Request, 0)
+	for i := range routes.Items {
+		if routes.Items[i].Spec.TrafficRoutes.Discovery != nil {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&routes.Items[i])})
 		}
 	}
+	return requests
+}
+
+// endpointSliceToServiceRoutes maps an EndpointSlice to the ServiceRoutes
+// routing its Service, or owning it through a subset Service.
+func (r *ServiceRouteReconciler) endpointSliceToServiceRoutes(obj client.Object) []reconcile.Request {
+	service, ok := obj.GetLabels()[discoveryv1.LabelServiceName]
+	if !ok {
+		return nil
+	}
+	routes, err := r.serviceRoutesOfNamespace(obj.GetNamespace())
+	if err != nil {
+		r.Log.Error(err, "unable to list ServiceRoutes", "namespace", obj.GetNamespace())
+		return nil
+	}
 
-	return httpRoutes
+	requests := make([]reconcile.Request, 0)
+	for i := range routes.Items {
+		key, _ := routes.Items[i].GetServiceKey()
+		matches := key.Name == service
+		status := &routes.Items[i].Status
+		for _, subset := range routeSubsets(routes.Items[i].WithDiscoveredSubsets(status.Channels, status.ChannelLabel)) {
+			matches = matches || router.SubsetServiceName(key.Name, subset.Name) == service
+		}
+		if matches {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&routes.Items[i])})
+		}
+	}
+	return requests
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *ServiceRouteReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&routev1alpha1.ServiceRoute{}).
-		Owns(&istiov1.DestinationRule{}).
-		Owns(&istiov1.VirtualService{}).
-		Complete(r)
+// serviceRoutesOfNamespace lists the ServiceRoutes routing a Service of
+// namespace, wherever they are.
+func (r *ServiceRouteReconciler) serviceRoutesOfNamespace(namespace string) (*routev1alpha1.ServiceRouteList, error) {
+	routes := &routev1alpha1.ServiceRouteList{}
+	if err := r.List(context.Background(), routes, client.MatchingFields{serviceNamespaceField: namespace}); err != nil {
+		return nil, fmt.Errorf("ServiceRoute list query error: %w", err)
+	}
+	return routes, nil
 }
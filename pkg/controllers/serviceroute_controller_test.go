@@ -0,0 +1,512 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "kubeorbit.io/api/v1"
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+var _ = Describe("ServiceRoute controller with the linkerd provider", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+		route     *routev1alpha1.ServiceRoute
+	)
+
+	getHTTPRoute := func(name string) (*unstructured.Unstructured, error) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(linkerdHTTPRouteGVK)
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, obj)
+		return obj, err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "linkerd-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		namespace = ns.Name
+
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "reviews"},
+				Ports: []corev1.ServicePort{{
+					Name:       "http",
+					Port:       9080,
+					TargetPort: intstr.FromInt(8080),
+				}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, svc)).To(Succeed())
+
+		route = &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name:         "reviews",
+				MeshProvider: meshProviderLinkerd,
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:   "dev",
+						Labels: map[string]string{"version": "dev"},
+						Match: &routev1alpha1.HTTPMatchRequest{Headers: map[string]*routev1alpha1.StringMatch{
+							"version": {Exact: "dev"},
+						}},
+						Weights: []routev1alpha1.WeightedDestination{
+							{Subset: "dev", Weight: 80},
+							{Subset: "base", Weight: 20},
+						},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+	})
+
+	It("renders a policy.linkerd.io HTTPRoute per Service port", func() {
+		var obj *unstructured.Unstructured
+		Eventually(func() error {
+			var err error
+			obj, err = getHTTPRoute("reviews-9080")
+			return err
+		}, timeout, interval).Should(Succeed())
+
+		Expect(obj.GetLabels()).To(HaveKeyWithValue(serviceRouteLabel, "reviews"))
+		Expect(obj.GetLabels()).To(HaveKeyWithValue(providerLabel, meshProviderLinkerd))
+
+		parentRefs, _, err := unstructured.NestedSlice(obj.Object, "spec", "parentRefs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parentRefs).To(ConsistOf(map[string]interface{}{
+			"group": "core",
+			"kind":  "Service",
+			"name":  "reviews",
+			"port":  int64(9080),
+		}))
+
+		rules, _, err := unstructured.NestedSlice(obj.Object, "spec", "rules")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(2))
+
+		By("matching the subset header")
+		matches, _, err := unstructured.NestedSlice(rules[0].(map[string]interface{}), "matches")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matches).To(HaveLen(1))
+		headers, _, err := unstructured.NestedSlice(matches[0].(map[string]interface{}), "headers")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(headers).To(ConsistOf(map[string]interface{}{
+			"type":  "Exact",
+			"name":  "version",
+			"value": "dev",
+		}))
+
+		By("splitting the matched traffic between the subset Services")
+		Expect(backendWeights(rules[0])).To(Equal(map[string]int64{"reviews-dev": 80, "reviews-base": 20}))
+
+		By("sending the other requests to the baseline")
+		Expect(backendWeights(rules[1])).To(Equal(map[string]int64{"reviews-base": 100}))
+
+		By("creating the subset Services")
+		for subset, version := range map[string]string{"reviews-dev": "dev", "reviews-base": "base"} {
+			svc := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: subset}, svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(Equal(map[string]string{"app": "reviews", "version": version}))
+		}
+
+		By("leaving the stored route alone once rendered")
+		// An annotation triggers a reconcile without changing the spec.
+		Eventually(func() error {
+			latest := &routev1alpha1.ServiceRoute{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: route.Name}, latest); err != nil {
+				return err
+			}
+			latest.Annotations = map[string]string{"touched": "true"}
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+		resourceVersion := obj.GetResourceVersion()
+		Consistently(func() (string, error) {
+			latest, err := getHTTPRoute("reviews-9080")
+			return latest.GetResourceVersion(), err
+		}, 2*time.Second, interval).Should(Equal(resourceVersion))
+	})
+
+	It("removes the HTTPRoute once the ServiceRoute leaves the provider", func() {
+		Eventually(func() error {
+			_, err := getHTTPRoute("reviews-9080")
+			return err
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() error {
+			latest := &routev1alpha1.ServiceRoute{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: route.Name}, latest); err != nil {
+				return err
+			}
+			latest.Spec.MeshProvider = meshProviderIstio
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() bool {
+			_, err := getHTTPRoute("reviews-9080")
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("leaves the HTTPRoute to the garbage collector once the ServiceRoute is deleted", func() {
+		var obj *unstructured.Unstructured
+		Eventually(func() error {
+			var err error
+			obj, err = getHTTPRoute("reviews-9080")
+			return err
+		}, timeout, interval).Should(Succeed())
+
+		// envtest runs no garbage collector: the controller reference is
+		// what removes the route from a real cluster.
+		owner := metav1.GetControllerOf(obj)
+		Expect(owner).NotTo(BeNil())
+		Expect(owner.Kind).To(Equal("ServiceRoute"))
+		Expect(owner.UID).To(Equal(route.UID))
+		Expect(owner.BlockOwnerDeletion).NotTo(BeNil())
+		Expect(*owner.BlockOwnerDeletion).To(BeTrue())
+
+		Expect(k8sClient.Delete(ctx, route)).To(Succeed())
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: route.Name}, &routev1alpha1.ServiceRoute{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+})
+
+// backendWeights returns the weight of each backend of an HTTPRoute rule by
+// Service name.
+func backendWeights(rule interface{}) map[string]int64 {
+	refs, _, err := unstructured.NestedSlice(rule.(map[string]interface{}), "backendRefs")
+	Expect(err).NotTo(HaveOccurred())
+	weights := make(map[string]int64, len(refs))
+	for _, ref := range refs {
+		ref := ref.(map[string]interface{})
+		Expect(ref).To(HaveKeyWithValue("port", int64(9080)))
+		weights[ref["name"].(string)] = ref["weight"].(int64)
+	}
+	return weights
+}
+
+var _ = Describe("ServiceRoute controller status", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+		route     *routev1alpha1.ServiceRoute
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = newTestNamespace(ctx, "status-")
+		newReviewsService(ctx, namespace)
+		newReviewsPod(ctx, namespace, "reviews-dev", map[string]string{"version": "dev"}, true)
+		newReviewsPod(ctx, namespace, "reviews-base", map[string]string{"version": "base"}, false)
+		// Pods of other Services are not counted, whatever their labels.
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "ratings-dev", Namespace: namespace,
+				Labels: map[string]string{"app": "ratings", "version": "dev"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "ratings", Image: "ratings"}}},
+		}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+
+		route = &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name: "reviews",
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					TrafficSubset: []*routev1alpha1.Subset{{
+						Name:    "dev",
+						Labels:  map[string]string{"version": "dev"},
+						Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+					}},
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+	})
+
+	It("reports the endpoints of each subset and the sync conditions", func() {
+		Eventually(func() ([]routev1alpha1.SubsetStatus, error) {
+			latest, err := getServiceRoute(ctx, route)
+			return latest.Status.Subsets, err
+		}, timeout, interval).Should(ConsistOf(
+			routev1alpha1.SubsetStatus{Name: "dev", Endpoints: 1, ReadyEndpoints: 1},
+			routev1alpha1.SubsetStatus{Name: "base", Endpoints: 1, ReadyEndpoints: 0},
+		))
+
+		latest, err := getServiceRoute(ctx, route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.ObservedGeneration).To(Equal(latest.Generation))
+		conditions := latest.Status.Conditions
+		Expect(meta.IsStatusConditionTrue(conditions, routev1alpha1.ServiceRouteConditionProviderSupported)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(conditions, routev1alpha1.ServiceRouteConditionDestinationRuleSynced)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(conditions, routev1alpha1.ServiceRouteConditionVirtualServiceSynced)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(conditions, routev1alpha1.ServiceRouteConditionConflicted)).To(BeTrue())
+
+		available := meta.FindStatusCondition(conditions, routev1alpha1.ServiceRouteConditionSubsetsAvailable)
+		Expect(available).NotTo(BeNil())
+		Expect(available.Status).To(Equal(metav1.ConditionFalse))
+		Expect(available.Reason).To(Equal(reasonNoReadyEndpoints))
+		Expect(available.Message).To(Equal("subsets without ready endpoints: base"))
+		Expect(meta.IsStatusConditionFalse(conditions, routev1alpha1.ServiceRouteConditionReady)).To(BeTrue())
+
+		By("turning ready once every subset has a ready endpoint")
+		setEndpointReady(ctx, namespace, "reviews-base", true)
+		Eventually(func() (bool, error) {
+			latest, err := getServiceRoute(ctx, route)
+			return meta.IsStatusConditionTrue(latest.Status.Conditions, routev1alpha1.ServiceRouteConditionReady), err
+		}, timeout, interval).Should(BeTrue())
+		latest, err = getServiceRoute(ctx, route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.Subsets).To(ContainElement(routev1alpha1.SubsetStatus{Name: "base", Endpoints: 1, ReadyEndpoints: 1}))
+	})
+
+	It("reports a missing Service", func() {
+		Eventually(func() (bool, error) {
+			latest, err := getServiceRoute(ctx, route)
+			return meta.IsStatusConditionTrue(latest.Status.Conditions, routev1alpha1.ServiceRouteConditionDestinationRuleSynced), err
+		}, timeout, interval).Should(BeTrue())
+
+		Eventually(func() error {
+			latest, err := getServiceRoute(ctx, route)
+			if err != nil {
+				return err
+			}
+			latest.Spec.Name = "details"
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() (*metav1.Condition, error) {
+			latest, err := getServiceRoute(ctx, route)
+			return meta.FindStatusCondition(latest.Status.Conditions, routev1alpha1.ServiceRouteConditionSubsetsAvailable), err
+		}, timeout, interval).Should(And(Not(BeNil()), WithTransform(func(c *metav1.Condition) string {
+			return c.Reason
+		}, Equal(reasonServiceNotFound))))
+		latest, err := getServiceRoute(ctx, route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.Subsets).To(BeEmpty())
+	})
+})
+
+// getServiceRoute returns the stored version of route.
+func getServiceRoute(ctx context.Context, route *routev1alpha1.ServiceRoute) (*routev1alpha1.ServiceRoute, error) {
+	latest := &routev1alpha1.ServiceRoute{}
+	err := k8sClient.Get(ctx, client.ObjectKeyFromObject(route), latest)
+	return latest, err
+}
+
+// newTestNamespace creates a namespace for one spec and returns its name.
+func newTestNamespace(ctx context.Context, prefix string) string {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: prefix}}
+	Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+	return ns.Name
+}
+
+// newReviewsService creates the reviews Service the specs route, selecting
+// the pods labelled app=reviews.
+func newReviewsService(ctx context.Context, namespace string) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "reviews"},
+			Ports:    []corev1.ServicePort{{Name: "http", Port: 9080, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+	Expect(k8sClient.Create(ctx, svc)).To(Succeed())
+}
+
+// newReviewsPod creates a pod of the reviews Service carrying podLabels too.
+// envtest runs no endpoints controller, so the pod is also listed in an
+// EndpointSlice of the Service named after it.
+func newReviewsPod(ctx context.Context, namespace, name string, podLabels map[string]string, ready bool) {
+	labels := map[string]string{"app": "reviews"}
+	for k, v := range podLabels {
+		labels[k] = v
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "reviews", Image: "reviews"}}},
+	}
+	Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "reviews"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{"10.0.0.1"},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Namespace: namespace, Name: name},
+		}},
+	}
+	Expect(k8sClient.Create(ctx, slice)).To(Succeed())
+}
+
+// setEndpointReady changes the readiness of a pod created by newReviewsPod.
+func setEndpointReady(ctx context.Context, namespace, name string, ready bool) {
+	Eventually(func() error {
+		slice := &discoveryv1.EndpointSlice{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, slice); err != nil {
+			return err
+		}
+		slice.Endpoints[0].Conditions.Ready = &ready
+		return k8sClient.Update(ctx, slice)
+	}, 10*time.Second, 250*time.Millisecond).Should(Succeed())
+}
+
+var _ = Describe("ServiceRoute controller channel discovery", func() {
+	const (
+		timeout      = 10 * time.Second
+		interval     = 250 * time.Millisecond
+		channelLabel = "kubeorbit.io/channel"
+	)
+
+	var (
+		ctx       context.Context
+		namespace string
+		route     *routev1alpha1.ServiceRoute
+	)
+
+	// routedChannels returns the channel header value matched by each route
+	// of the VirtualService, with the subset it goes to.
+	routedChannels := func() (map[string]string, error) {
+		virtualService, err := getVirtualService(ctx, namespace, "reviews")
+		if err != nil {
+			return nil, err
+		}
+		channels := map[string]string{}
+		for _, r := range virtualService.Spec.Http {
+			if len(r.Match) == 0 {
+				continue
+			}
+			channels[r.Match[0].Headers["channel"].GetExact()] = r.Route[0].Destination.Subset
+		}
+		return channels, nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		// The namespace picks its own channel label, which names the
+		// header too.
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "discovery-",
+			Annotations:  map[string]string{v1.KUBEORBIT_CHANNEL_LABEL_ANNOTATION: channelLabel},
+		}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		namespace = ns.Name
+
+		newReviewsService(ctx, namespace)
+		newReviewsPod(ctx, namespace, "reviews-base", map[string]string{"version": "base"}, true)
+		newReviewsPod(ctx, namespace, "reviews-dev", map[string]string{channelLabel: "dev"}, true)
+		newReviewsPod(ctx, namespace, "reviews-test", map[string]string{channelLabel: "test"}, true)
+
+		route = &routev1alpha1.ServiceRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: namespace},
+			Spec: routev1alpha1.ServiceRouteSpec{
+				Name: "reviews",
+				TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+					Baseline: &routev1alpha1.BaselineRoute{
+						Name:   "base",
+						Labels: map[string]string{"version": "base"},
+					},
+					Discovery: &routev1alpha1.DiscoverySpec{},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, route)).To(Succeed())
+	})
+
+	It("routes every channel found on the pods", func() {
+		Eventually(routedChannels, timeout, interval).Should(Equal(map[string]string{"dev": "dev", "test": "test"}))
+
+		latest, err := getServiceRoute(ctx, route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.ChannelLabel).To(Equal(channelLabel))
+		Expect(latest.Status.Channels).To(Equal([]string{"dev", "test"}))
+		Expect(latest.Status.Subsets).To(ConsistOf(
+			routev1alpha1.SubsetStatus{Name: "dev", Endpoints: 1, ReadyEndpoints: 1},
+			routev1alpha1.SubsetStatus{Name: "test", Endpoints: 1, ReadyEndpoints: 1},
+			routev1alpha1.SubsetStatus{Name: "base", Endpoints: 1, ReadyEndpoints: 1},
+		))
+		Expect(latest.Spec.TrafficRoutes.TrafficSubset).To(BeEmpty())
+
+		By("pruning the channel of the last pod gone")
+		Expect(k8sClient.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "reviews-test"}})).To(Succeed())
+		Eventually(routedChannels, timeout, interval).Should(Equal(map[string]string{"dev": "dev"}))
+		latest, err = getServiceRoute(ctx, route)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Status.Channels).To(Equal([]string{"dev"}))
+	})
+
+	It("lets declared subsets win over discovered ones", func() {
+		Eventually(routedChannels, timeout, interval).Should(HaveLen(2))
+
+		Eventually(func() error {
+			latest, err := getServiceRoute(ctx, route)
+			if err != nil {
+				return err
+			}
+			latest.Spec.TrafficRoutes.TrafficSubset = []*routev1alpha1.Subset{{
+				Name:    "dev",
+				Labels:  map[string]string{channelLabel: "dev"},
+				Headers: map[string]*routev1alpha1.StringMatch{"channel": {Exact: "canary"}},
+			}}
+			return k8sClient.Update(ctx, latest)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(routedChannels, timeout, interval).Should(Equal(map[string]string{"canary": "dev", "test": "test"}))
+	})
+})
@@ -17,19 +17,27 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	istiov1 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	v1 "kubeorbit.io/api/v1"
 	networkv1alpha1 "kubeorbit.io/api/v1alpha1"
 	//+kubebuilder:scaffold:imports
 )
@@ -40,6 +48,11 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var cancel context.CancelFunc
+
+// istioRootNamespace is where the istio provider places the EnvoyFilters of
+// mesh scoped Orbits.
+const istioRootNamespace = "istio-system"
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -54,7 +67,12 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "config", "crd", "bases"),
+			filepath.Join("testdata", "crds"),
+			filepath.Join(moduleDir("sigs.k8s.io/gateway-api"), "config", "crd", "experimental"),
+			filepath.Join(moduleDir("istio.io/api"), "kubernetes"),
+		},
 		ErrorIfCRDPathMissing: true,
 	}
 
@@ -64,6 +82,8 @@ var _ = BeforeSuite(func() {
 
 	err = networkv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = istiov1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
@@ -71,10 +91,62 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	err = k8sClient.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: istioRootNamespace}})
+	Expect(err).NotTo(HaveOccurred())
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	channelLabels := &v1.ChannelLabels{Client: mgr.GetClient(), Default: v1.KUBEORBIT_CHANNEL_LABEL}
+	providers, err := NewProviderRegistry([]string{meshProviderIstio, meshProviderLinkerd, meshProviderGatewayAPI}, meshProviderIstio, ProviderOptions{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Log:                mgr.GetLogger(),
+		IstioRootNamespace: istioRootNamespace,
+		ChannelLabels:      channelLabels,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&ServiceRouteReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Log:           mgr.GetLogger(),
+		Providers:     providers,
+		ChannelLabels: channelLabels,
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&OrbitReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Log:       mgr.GetLogger(),
+		Providers: providers,
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		err := mgr.Start(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	}()
 }, 60)
 
+// moduleDir returns the directory of a module the tests depend on, to load
+// the CRDs it ships.
+func moduleDir(path string) string {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", path).Output()
+	Expect(err).NotTo(HaveOccurred())
+	return strings.TrimSpace(string(out))
+}
+
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	cancel()
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// MeshProvider is the spec.provider value of the objects served by the
+	// router.
+	MeshProvider = "none"
+	// PodLabel selects the router pods serving a ServiceRoute.
+	PodLabel = "network.kubeorbit.io/router"
+	// SelectorAnnotation keeps the selector a Service had before the router
+	// took it over, as JSON.
+	SelectorAnnotation = "network.kubeorbit.io/selector"
+)
+
+// SubsetServiceName names the Service selecting one subset of a Service,
+// shortening it with a hash when it would not be a valid Service name.
+func SubsetServiceName(service, subset string) string {
+	name := fmt.Sprintf("%s-%s", service, subset)
+	if len(name) <= validation.DNS1035LabelMaxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:8]
+	return fmt.Sprintf("%s-%s", name[:validation.DNS1035LabelMaxLength-len(hash)-1], hash)
+}
+
+// ListenPort is the port the router listens on for one port of the routed
+// Service: the numeric target port, or the Service port when the target port
+// is named, in which case the router container port carries that name. Either
+// way taking the Service over only changes its selector.
+func ListenPort(port corev1.ServicePort) int32 {
+	if port.TargetPort.IntVal != 0 {
+		return port.TargetPort.IntVal
+	}
+	return port.Port
+}
+
+// AppSelector returns the selector of the routed Service, as it was before
+// the router took the Service over.
+func AppSelector(target *corev1.Service) (map[string]string, error) {
+	saved, ok := target.Annotations[SelectorAnnotation]
+	if !ok {
+		return target.Spec.Selector, nil
+	}
+	selector := map[string]string{}
+	if err := json.Unmarshal([]byte(saved), &selector); err != nil {
+		return nil, fmt.Errorf("Service %s.%s has an invalid %s annotation: %w",
+			target.Name, target.Namespace, SelectorAnnotation, err)
+	}
+	return selector, nil
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+// upstreamKey carries the endpoint picked for a request to the proxy.
+type upstreamKey struct{}
+
+// Router is a reverse proxy sending each request to the subset matching its
+// headers. It serves HTTP/1.1 and cleartext HTTP/2 on every listen port and
+// talks to the endpoints with the protocol of the request.
+type Router struct {
+	log   logr.Logger
+	ports []int32
+	table atomic.Value
+	proxy *httputil.ReverseProxy
+}
+
+// New creates a router listening on ports. It answers 503 until a table is
+// set.
+func New(log logr.Logger, ports []int32) *Router {
+	r := &Router{log: log, ports: ports}
+	r.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host, _ = req.Context().Value(upstreamKey{}).(string)
+		},
+		Transport: &transport{
+			http1: http.DefaultTransport,
+			http2: &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			},
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			r.log.Error(err, "upstream request failed", "upstream", req.URL.Host)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return r
+}
+
+// SetTable replaces the routing table.
+func (r *Router) SetTable(table *Table) {
+	r.table.Store(table)
+}
+
+// Ready fails until a routing table is set. It is meant as a readiness
+// check.
+func (r *Router) Ready(_ *http.Request) error {
+	if r.table.Load() == nil {
+		return errors.New("routing table not loaded")
+	}
+	return nil
+}
+
+// Start serves every listen port until ctx is done.
+func (r *Router) Start(ctx context.Context) error {
+	servers := make([]*http.Server, 0, len(r.ports))
+	errs := make(chan error, len(r.ports))
+	for _, port := range r.ports {
+		server := &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           h2c.NewHandler(r.handler(port), &http2.Server{}),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers = append(servers, server)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("%s listen error: %w", server.Addr, err)
+			}
+		}()
+		r.log.Info("router listening", "address", server.Addr)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
+}
+
+func (r *Router) handler(port int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		table, _ := r.table.Load().(*Table)
+		if table == nil {
+			http.Error(w, "routing table not loaded", http.StatusServiceUnavailable)
+			return
+		}
+		routes, ok := table.Ports[port]
+		if !ok {
+			http.Error(w, "port is not routed", http.StatusServiceUnavailable)
+			return
+		}
+		backend, mirror := routes.match(req)
+		if backend == nil {
+			http.Error(w, "no route", http.StatusNotFound)
+			return
+		}
+		addr, ok := backend.pick()
+		if !ok {
+			http.Error(w, fmt.Sprintf("no ready endpoint for subset %s", backend.Subset), http.StatusServiceUnavailable)
+			return
+		}
+
+		if mirror != nil {
+			r.mirror(req, mirror)
+		}
+		setMissingHeaders(req.Header, backend.Propagate)
+		r.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), upstreamKey{}, addr)))
+	})
+}
+
+const (
+	// maxMirrorBody is the largest request body copied to a mirror.
+	// Requests with larger bodies, or bodies of unknown length, are not
+	// mirrored.
+	maxMirrorBody = 1 << 20
+	// mirrorTimeout bounds the mirrored requests.
+	mirrorTimeout = 30 * time.Second
+)
+
+// hopHeaders are the hop-by-hop headers left out of mirrored requests.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade"}
+
+// mirror sends a copy of req to the backend in the background and discards
+// the response. The body of req is buffered so that both requests can read
+// it.
+func (r *Router) mirror(req *http.Request, backend *Backend) {
+	if req.ContentLength < 0 || req.ContentLength > maxMirrorBody || req.Header.Get("Upgrade") != "" {
+		return
+	}
+	addr, ok := backend.pick()
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxMirrorBody))
+	// Whatever could not be read is left for the proxy, which reports it.
+	req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+	if err != nil {
+		return
+	}
+
+	shadow := req.Clone(context.Background())
+	shadow.RequestURI = ""
+	shadow.URL.Scheme = "http"
+	shadow.URL.Host = addr
+	// Like Istio, tell mirrored requests apart by their authority.
+	shadow.Host = req.Host + "-shadow"
+	shadow.Body = io.NopCloser(bytes.NewReader(body))
+	shadow.ContentLength = int64(len(body))
+	for _, h := range hopHeaders {
+		shadow.Header.Del(h)
+	}
+	setMissingHeaders(shadow.Header, backend.Propagate)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+		defer cancel()
+		resp, err := r.proxy.Transport.RoundTrip(shadow.WithContext(ctx))
+		if err != nil {
+			r.log.V(1).Info("mirror request failed", "upstream", addr, "error", err.Error())
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// setMissingHeaders sets the propagated headers the request lacks.
+func setMissingHeaders(header http.Header, propagate []routev1alpha1.HeaderPropagation) {
+	for _, h := range propagate {
+		if header.Get(h.Name) == "" {
+			header.Set(h.Name, h.Value)
+		}
+	}
+}
+
+// transport sends requests upstream with the HTTP version they came in
+// with, so that HTTP/2 only protocols such as gRPC keep working.
+type transport struct {
+	http1 http.RoundTripper
+	http2 http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ProtoMajor == 2 {
+		return t.http2.RoundTrip(req)
+	}
+	return t.http1.RoundTrip(req)
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1 "kubeorbit.io/api/v1"
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+// channelEnv is the sidecar variable holding the channel of a pod, which
+// header propagation rules read by default.
+const channelEnv = v1.KUBEORBIT_CHANNEL_ENV
+
+// neverMatch stands in for header expressions that do not compile.
+var neverMatch = regexp.MustCompile(`[^\s\S]`)
+
+// Table is the routing state of a router, built from the ServiceRoute, the
+// routed Service, the Endpoints of the subset Services and the Orbits of the
+// namespace.
+type Table struct {
+	// Ports holds the routes of each listen port.
+	Ports map[int32]*PortRoutes
+}
+
+// TableOptions tells how Orbits apply to the subsets of a Table.
+type TableOptions struct {
+	// ChannelLabel is the pod label holding the channel of the subset pods.
+	ChannelLabel string
+	// DefaultProvider is the mesh provider of the Orbits that do not set
+	// spec.provider.
+	DefaultProvider string
+}
+
+// PortRoutes routes the traffic of one port of the routed Service.
+type PortRoutes struct {
+	Rules []Rule
+	// Default takes the requests matching no rule, empty when the
+	// ServiceRoute has no default route.
+	Default Split
+	// Mirror receives a copy of MirrorPercentage percent of the requests
+	// taken by Default, nil when the ServiceRoute has no mirror.
+	Mirror           *Backend
+	MirrorPercentage int32
+}
+
+// Rule sends the requests meeting every condition to Split. Conditions
+// are expressions the whole value must match; nil ones match anything.
+type Rule struct {
+	URI       *regexp.Regexp
+	Method    *regexp.Regexp
+	Authority *regexp.Regexp
+	// Headers and QueryParams must be present and match, by name.
+	Headers     map[string]*regexp.Regexp
+	QueryParams map[string]*regexp.Regexp
+	// WithoutHeaders must be absent or not match, by name.
+	WithoutHeaders map[string]*regexp.Regexp
+	Split          Split
+}
+
+func (r *Rule) matches(req *http.Request) bool {
+	if r.URI != nil && !r.URI.MatchString(req.URL.Path) ||
+		r.Method != nil && !r.Method.MatchString(req.Method) ||
+		r.Authority != nil && !r.Authority.MatchString(req.Host) {
+		return false
+	}
+	for k, re := range r.Headers {
+		values := req.Header.Values(k)
+		if len(values) == 0 || !re.MatchString(values[0]) {
+			return false
+		}
+	}
+	query := req.URL.Query()
+	for k, re := range r.QueryParams {
+		values, ok := query[k]
+		if !ok || !re.MatchString(values[0]) {
+			return false
+		}
+	}
+	for k, re := range r.WithoutHeaders {
+		values := req.Header.Values(k)
+		if len(values) > 0 && re.MatchString(values[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Split shares traffic between backends by weight.
+type Split []*Backend
+
+// pick returns a backend at random, in proportion to the weights. It
+// returns nil when no backend has weight.
+func (s Split) pick() *Backend {
+	total := int32(0)
+	for _, b := range s {
+		total += b.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+	n := rand.Int31n(total)
+	for _, b := range s {
+		if n < b.Weight {
+			return b
+		}
+		n -= b.Weight
+	}
+	return nil
+}
+
+// available tells whether a backend with weight has an endpoint.
+func (s Split) available() bool {
+	for _, b := range s {
+		if b.Weight > 0 && len(b.Addrs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Backend is the set of ready endpoints of one subset.
+type Backend struct {
+	Subset string
+	Weight int32
+	Addrs  []string
+	// Propagate lists the headers the Orbits of the router provider
+	// selecting the subset pods propagate, with their values: literal ones
+	// or the channel of the subset. The router sets them on the requests
+	// sent to the backend that lack them.
+	Propagate []routev1alpha1.HeaderPropagation
+	next      uint32
+}
+
+// pick returns the next endpoint address, round robin.
+func (b *Backend) pick() (string, bool) {
+	if len(b.Addrs) == 0 {
+		return "", false
+	}
+	n := atomic.AddUint32(&b.next, 1)
+	return b.Addrs[int(n-1)%len(b.Addrs)], true
+}
+
+// match returns the backend of the first rule matching the request, and
+// the backend the request is mirrored to, if any.
+func (p *PortRoutes) match(req *http.Request) (*Backend, *Backend) {
+	for i := range p.Rules {
+		if p.Rules[i].matches(req) {
+			return p.Rules[i].Split.pick(), nil
+		}
+	}
+	var mirror *Backend
+	if p.Mirror != nil && rand.Int31n(100) < p.MirrorPercentage {
+		mirror = p.Mirror
+	}
+	return p.Default.pick(), mirror
+}
+
+// BuildTable computes the routing table. endpoints holds the Endpoints of
+// the subset Services by name; missing ones leave their subset without
+// endpoints.
+func BuildTable(tr *routev1alpha1.ServiceRoute, service *corev1.Service,
+	endpoints map[string]*corev1.Endpoints, orbits []routev1alpha1.Orbit, opts TableOptions) *Table {
+	table := &Table{Ports: make(map[int32]*PortRoutes, len(service.Spec.Ports))}
+	propagate := subsetPropagation(tr, service, orbits, opts)
+
+	for _, port := range service.Spec.Ports {
+		backend := func(d routev1alpha1.WeightedDestination) *Backend {
+			b := newBackend(d, endpoints[SubsetServiceName(service.Name, d.Subset)], port)
+			b.Propagate = propagate(d.Subset)
+			return b
+		}
+		split := func(destinations []routev1alpha1.WeightedDestination) Split {
+			split := make(Split, 0, len(destinations))
+			for _, d := range destinations {
+				split = append(split, backend(d))
+			}
+			return split
+		}
+
+		routes := &PortRoutes{Default: split(tr.Spec.TrafficRoutes.DefaultDestinations())}
+		if m := tr.Spec.TrafficRoutes.Mirror; m != nil && m.MirrorPercentage() > 0 {
+			routes.Mirror = backend(routev1alpha1.WeightedDestination{Subset: m.Subset, Weight: 100})
+			routes.MirrorPercentage = m.MirrorPercentage()
+		}
+		for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+			if !c.Routed() {
+				continue
+			}
+			// Fallback routes are left out while no endpoint is ready,
+			// like the providers do from the ServiceRoute status.
+			destinations := split(c.Destinations())
+			if c.Fallback && !destinations.available() {
+				continue
+			}
+			match := c.HTTPMatch()
+			routes.Rules = append(routes.Rules, Rule{
+				URI:            compileMatch(match.URI),
+				Method:         compileMatch(match.Method),
+				Authority:      compileMatch(match.Authority),
+				Headers:        compileMatches(match.Headers),
+				QueryParams:    compileMatches(match.QueryParams),
+				WithoutHeaders: compileMatches(match.WithoutHeaders),
+				Split:          destinations,
+			})
+		}
+		table.Ports[ListenPort(port)] = routes
+	}
+	return table
+}
+
+// subsetPropagation returns the headers propagated on the requests sent to
+// each subset, by subset name. The Orbits of the router provider apply to
+// a subset when they select its pods, which carry the labels of the routed
+// Service selector and of the subset. The headers reading
+// ORBIT_CHANNEL_TAG get the channel of the subset; the ones reading other
+// variables are left to the applications, as the router has no sidecar
+// environment to read them from.
+func subsetPropagation(tr *routev1alpha1.ServiceRoute, service *corev1.Service,
+	orbits []routev1alpha1.Orbit, opts TableOptions) func(subset string) []routev1alpha1.HeaderPropagation {
+	selector, _ := AppSelector(service)
+	subsetLabels := map[string]map[string]string{}
+	for _, c := range tr.Spec.TrafficRoutes.TrafficSubset {
+		if c != nil && c.Labels != nil {
+			subsetLabels[c.Name] = c.Labels
+		}
+	}
+	if c := tr.Spec.TrafficRoutes.BaselineRoute().Subset(); c != nil {
+		subsetLabels[c.Name] = c.Labels
+	}
+
+	providerOrbits := make([]*routev1alpha1.Orbit, 0, len(orbits))
+	for i := range orbits {
+		provider := orbits[i].Spec.MeshProvider
+		if provider == "" {
+			provider = opts.DefaultProvider
+		}
+		if provider == MeshProvider {
+			providerOrbits = append(providerOrbits, &orbits[i])
+		}
+	}
+
+	return func(subset string) []routev1alpha1.HeaderPropagation {
+		podLabels := make(map[string]string, len(selector)+len(subsetLabels[subset]))
+		for k, v := range selector {
+			podLabels[k] = v
+		}
+		for k, v := range subsetLabels[subset] {
+			podLabels[k] = v
+		}
+		channel := podLabels[opts.ChannelLabel]
+
+		var headers []routev1alpha1.HeaderPropagation
+		for _, orbit := range providerOrbits {
+			if !orbit.Selects(tr.Namespace, podLabels) {
+				continue
+			}
+			for _, h := range orbit.Spec.TrafficRules.PropagatedHeaders() {
+				value := h.Value
+				if value == "" && (h.Env == "" || h.Env == channelEnv) {
+					value = channel
+				}
+				if value != "" {
+					headers = append(headers, routev1alpha1.HeaderPropagation{Name: h.Name, Value: value})
+				}
+			}
+		}
+		sort.SliceStable(headers, func(i, j int) bool {
+			return headers[i].Name < headers[j].Name
+		})
+		return headers
+	}
+}
+
+// compileMatch turns a StringMatch into an expression the whole value must
+// match. Expressions that do not compile never match, rather than widen the
+// route.
+func compileMatch(match *routev1alpha1.StringMatch) *regexp.Regexp {
+	if match == nil {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + match.AsRegex() + ")$")
+	if err != nil {
+		return neverMatch
+	}
+	return re
+}
+
+func compileMatches(matches map[string]*routev1alpha1.StringMatch) map[string]*regexp.Regexp {
+	compiled := make(map[string]*regexp.Regexp, len(matches))
+	for k, match := range matches {
+		if match != nil {
+			compiled[k] = compileMatch(match)
+		}
+	}
+	return compiled
+}
+
+// newBackend collects the ready addresses of a subset Service for one port
+// of the routed Service. Endpoints name their ports after the Service ports.
+func newBackend(d routev1alpha1.WeightedDestination, ep *corev1.Endpoints, port corev1.ServicePort) *Backend {
+	b := &Backend{Subset: d.Subset, Weight: d.Weight}
+	if ep == nil {
+		return b
+	}
+	for _, s := range ep.Subsets {
+		for _, p := range s.Ports {
+			if p.Name != port.Name {
+				continue
+			}
+			for _, addr := range s.Addresses {
+				b.Addrs = append(b.Addrs, net.JoinHostPort(addr.IP, strconv.Itoa(int(p.Port))))
+			}
+		}
+	}
+	sort.Strings(b.Addrs)
+	return b
+}
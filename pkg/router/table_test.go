@@ -0,0 +1,315 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+func TestRuleMatches(t *testing.T) {
+	exact := func(s string) *regexp.Regexp { return compileMatch(&routev1alpha1.StringMatch{Exact: s}) }
+	tests := []struct {
+		name    string
+		rule    Rule
+		target  string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "no condition", rule: Rule{}, target: "/", want: true},
+		{
+			name:    "header",
+			rule:    Rule{Headers: map[string]*regexp.Regexp{"version": exact("dev")}},
+			target:  "/",
+			headers: map[string]string{"Version": "dev"},
+			want:    true,
+		},
+		{
+			name:    "header value must match whole",
+			rule:    Rule{Headers: map[string]*regexp.Regexp{"version": exact("dev")}},
+			target:  "/",
+			headers: map[string]string{"version": "dev2"},
+		},
+		{
+			name:   "missing header",
+			rule:   Rule{Headers: map[string]*regexp.Regexp{"version": exact("dev")}},
+			target: "/",
+		},
+		{
+			name:   "uri prefix",
+			rule:   Rule{URI: compileMatch(&routev1alpha1.StringMatch{Prefix: "/api/"})},
+			target: "/api/v1?x=1",
+			want:   true,
+		},
+		{
+			name:   "uri matches the path only",
+			rule:   Rule{URI: exact("/api")},
+			target: "/api?x=1",
+			want:   true,
+		},
+		{
+			name:   "method",
+			rule:   Rule{Method: exact("POST")},
+			target: "/",
+			method: "GET",
+		},
+		{
+			name:   "authority",
+			rule:   Rule{Authority: compileMatch(&routev1alpha1.StringMatch{Suffix: ".apps"})},
+			target: "http://reviews.apps/",
+			want:   true,
+		},
+		{
+			name:   "query param",
+			rule:   Rule{QueryParams: map[string]*regexp.Regexp{"debug": exact("1")}},
+			target: "/?debug=1",
+			want:   true,
+		},
+		{
+			name:   "missing query param",
+			rule:   Rule{QueryParams: map[string]*regexp.Regexp{"debug": exact("1")}},
+			target: "/?verbose=1",
+		},
+		{
+			name:    "without header present",
+			rule:    Rule{WithoutHeaders: map[string]*regexp.Regexp{"x-canary": exact("off")}},
+			target:  "/",
+			headers: map[string]string{"x-canary": "off"},
+		},
+		{
+			name:    "without header with another value",
+			rule:    Rule{WithoutHeaders: map[string]*regexp.Regexp{"x-canary": exact("off")}},
+			target:  "/",
+			headers: map[string]string{"x-canary": "on"},
+			want:    true,
+		},
+		{
+			name:    "invalid expression",
+			rule:    Rule{Headers: map[string]*regexp.Regexp{"version": compileMatch(&routev1alpha1.StringMatch{Regex: "("})}},
+			target:  "/",
+			headers: map[string]string{"version": "("},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := tt.rule.matches(req); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitPick(t *testing.T) {
+	if b := (Split{}).pick(); b != nil {
+		t.Errorf("empty split picked %s", b.Subset)
+	}
+	if b := (Split{{Subset: "a"}, {Subset: "b"}}).pick(); b != nil {
+		t.Errorf("split without weight picked %s", b.Subset)
+	}
+	if b := (Split{{Subset: "a"}, {Subset: "b", Weight: 100}}).pick(); b == nil || b.Subset != "b" {
+		t.Errorf("split picked %v, want b", b)
+	}
+
+	split := Split{{Subset: "a", Weight: 20}, {Subset: "b", Weight: 80}}
+	picked := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		picked[split.pick().Subset]++
+	}
+	if picked["a"] < 1500 || picked["a"] > 2500 {
+		t.Errorf("a picked %d times out of 10000, want about 2000", picked["a"])
+	}
+}
+
+func TestBuildTable(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "apps"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "reviews"},
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+				{Name: "grpc", Port: 9090, TargetPort: intstr.FromString("grpc")},
+			},
+		},
+	}
+	endpoints := func(name string, ips ...string) *corev1.Endpoints {
+		addrs := make([]corev1.EndpointAddress, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, corev1.EndpointAddress{IP: ip})
+		}
+		return &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: addrs,
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080}, {Name: "grpc", Port: 9000}},
+			}},
+		}
+	}
+	tr := &routev1alpha1.ServiceRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "apps"},
+		Spec: routev1alpha1.ServiceRouteSpec{
+			Name: "reviews",
+			TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+				TrafficSubset: []*routev1alpha1.Subset{
+					{
+						Name:    "dev",
+						Labels:  map[string]string{"version": "dev"},
+						Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+					},
+					{
+						Name:     "test",
+						Labels:   map[string]string{"version": "test"},
+						Headers:  map[string]*routev1alpha1.StringMatch{"version": {Exact: "test"}},
+						Fallback: true,
+					},
+				},
+				Baseline: &routev1alpha1.BaselineRoute{Name: "base", Labels: map[string]string{"version": "base"}},
+				Mirror:   &routev1alpha1.MirrorRoute{Subset: "dev"},
+			},
+		},
+	}
+	table := BuildTable(tr, service, map[string]*corev1.Endpoints{
+		"reviews-dev":  endpoints("reviews-dev", "10.0.0.2", "10.0.0.1"),
+		"reviews-base": endpoints("reviews-base", "10.0.1.1"),
+	}, nil, TableOptions{ChannelLabel: "version", DefaultProvider: MeshProvider})
+
+	if len(table.Ports) != 2 {
+		t.Fatalf("got %d ports, want 2", len(table.Ports))
+	}
+	http := table.Ports[8080]
+	if http == nil {
+		t.Fatalf("numeric target port 8080 is not routed")
+	}
+	// The fallback route has no endpoint, so only the dev rule is kept.
+	if len(http.Rules) != 1 {
+		t.Fatalf("got %d rules, want 1", len(http.Rules))
+	}
+	dev := http.Rules[0].Split
+	if len(dev) != 1 || dev[0].Subset != "dev" || dev[0].Weight != 100 {
+		t.Errorf("unexpected dev split %+v", dev)
+	}
+	if want := []string{"10.0.0.1:8080", "10.0.0.2:8080"}; !reflect.DeepEqual(dev[0].Addrs, want) {
+		t.Errorf("dev addresses = %v, want %v", dev[0].Addrs, want)
+	}
+	if len(http.Default) != 1 || http.Default[0].Subset != "base" ||
+		!reflect.DeepEqual(http.Default[0].Addrs, []string{"10.0.1.1:8080"}) {
+		t.Errorf("unexpected default split %+v", http.Default)
+	}
+	if http.Mirror == nil || http.Mirror.Subset != "dev" || http.MirrorPercentage != 100 {
+		t.Errorf("unexpected mirror %+v", http.Mirror)
+	}
+
+	// Named target ports listen on the Service port.
+	grpc := table.Ports[9090]
+	if grpc == nil {
+		t.Fatalf("named target port is not routed on the Service port")
+	}
+	if want := []string{"10.0.1.1:9000"}; !reflect.DeepEqual(grpc.Default[0].Addrs, want) {
+		t.Errorf("grpc default addresses = %v, want %v", grpc.Default[0].Addrs, want)
+	}
+}
+
+func TestBuildTablePropagation(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "reviews",
+			Namespace: "apps",
+			// Taken over by the router: the pods are selected by the
+			// saved selector.
+			Annotations: map[string]string{SelectorAnnotation: `{"app":"reviews"}`},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{PodLabel: "reviews"},
+			Ports:    []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+	tr := &routev1alpha1.ServiceRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "apps"},
+		Spec: routev1alpha1.ServiceRouteSpec{
+			Name: "reviews",
+			TrafficRoutes: routev1alpha1.TrafficRouteSpec{
+				TrafficSubset: []*routev1alpha1.Subset{{
+					Name:    "dev",
+					Labels:  map[string]string{"version": "dev"},
+					Headers: map[string]*routev1alpha1.StringMatch{"version": {Exact: "dev"}},
+				}},
+				Baseline: &routev1alpha1.BaselineRoute{Name: "base", Labels: map[string]string{"version": "base"}},
+			},
+		},
+	}
+	orbit := func(name, provider string, selector map[string]string, headers ...routev1alpha1.HeaderPropagation) routev1alpha1.Orbit {
+		o := routev1alpha1.Orbit{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+			Spec: routev1alpha1.OrbitSpec{
+				MeshProvider: provider,
+				TrafficRules: routev1alpha1.TrafficRulesSpec{Propagate: headers},
+			},
+		}
+		if selector != nil {
+			o.Spec.WorkloadSelector = &routev1alpha1.WorkloadSelector{Labels: selector}
+		}
+		return o
+	}
+	orbits := []routev1alpha1.Orbit{
+		// Defaults to the router provider.
+		orbit("channel", "", nil, routev1alpha1.HeaderPropagation{Name: "x-channel"}),
+		orbit("dev-only", MeshProvider, map[string]string{"app": "reviews", "version": "dev"},
+			routev1alpha1.HeaderPropagation{Name: "x-caller", Value: "reviews-dev"}),
+		orbit("other-app", MeshProvider, map[string]string{"app": "ratings"},
+			routev1alpha1.HeaderPropagation{Name: "x-ratings", Value: "1"}),
+		orbit("istio", "istio", nil, routev1alpha1.HeaderPropagation{Name: "x-istio", Value: "1"}),
+		// The router has no sidecar environment to read other variables
+		// from.
+		orbit("env", MeshProvider, nil, routev1alpha1.HeaderPropagation{Name: "x-tenant", Env: "TENANT_ID"}),
+	}
+
+	table := BuildTable(tr, service, nil, orbits, TableOptions{ChannelLabel: "version", DefaultProvider: MeshProvider})
+	routes := table.Ports[8080]
+	if got, want := routes.Rules[0].Split[0].Propagate, []routev1alpha1.HeaderPropagation{
+		{Name: "x-caller", Value: "reviews-dev"},
+		{Name: "x-channel", Value: "dev"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dev headers = %v, want %v", got, want)
+	}
+	if got, want := routes.Default[0].Propagate, []routev1alpha1.HeaderPropagation{
+		{Name: "x-channel", Value: "base"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("baseline headers = %v, want %v", got, want)
+	}
+
+	// Orbits without provider belong to the default provider.
+	table = BuildTable(tr, service, nil, orbits, TableOptions{ChannelLabel: "version", DefaultProvider: "istio"})
+	if got := table.Ports[8080].Default[0].Propagate; len(got) != 0 {
+		t.Errorf("baseline headers = %v, want none", got)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The TeamCode authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	routev1alpha1 "kubeorbit.io/api/v1alpha1"
+)
+
+// TableReconciler rebuilds the routing table of a Router whenever the
+// ServiceRoute it serves, the routed Service, an Endpoints or an Orbit of
+// the namespace changes.
+type TableReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Router *Router
+	// ServiceRoute is the ServiceRoute served by the router.
+	ServiceRoute k8stypes.NamespacedName
+	// Options tells how the Orbits apply to the subsets.
+	Options TableOptions
+}
+
+func (r *TableReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	tr := &routev1alpha1.ServiceRoute{}
+	if err := r.Get(ctx, r.ServiceRoute, tr); err != nil {
+		if errors.IsNotFound(err) {
+			r.Router.SetTable(&Table{})
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("ServiceRoute %s.%s get query error: %w", r.ServiceRoute.Name, r.ServiceRoute.Namespace, err)
+	}
+	// The controller publishes the discovered channels and their label in
+	// the status.
+	tr = tr.WithDiscoveredSubsets(tr.Status.Channels, tr.Status.ChannelLabel)
+
+	service := &corev1.Service{}
+	key, _ := tr.GetServiceKey()
+	if err := r.Get(ctx, key, service); err != nil {
+		return ctrl.Result{}, fmt.Errorf("Service %s.%s get query error: %w", key.Name, key.Namespace, err)
+	}
+
+	endpointsList := &corev1.EndpointsList{}
+	if err := r.List(ctx, endpointsList, client.InNamespace(tr.Namespace)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("Endpoints list query error: %w", err)
+	}
+	endpoints := make(map[string]*corev1.Endpoints, len(endpointsList.Items))
+	for i := range endpointsList.Items {
+		endpoints[endpointsList.Items[i].Name] = &endpointsList.Items[i]
+	}
+
+	orbits := &routev1alpha1.OrbitList{}
+	if err := r.List(ctx, orbits, client.InNamespace(tr.Namespace)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("Orbit list query error: %w", err)
+	}
+
+	r.Router.SetTable(BuildTable(tr, service, endpoints, orbits.Items, r.Options))
+	r.Log.V(1).Info("routing table updated", "serviceroute", r.ServiceRoute)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. Every watched
+// object maps to the one ServiceRoute served by the router.
+func (r *TableReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	served := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: r.ServiceRoute}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&routev1alpha1.ServiceRoute{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetName() == r.ServiceRoute.Name
+		}))).
+		Watches(&source.Kind{Type: &corev1.Service{}}, served).
+		Watches(&source.Kind{Type: &corev1.Endpoints{}}, served).
+		Watches(&source.Kind{Type: &routev1alpha1.Orbit{}}, served).
+		Complete(r)
+}